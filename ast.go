@@ -2,32 +2,37 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
 const (
-	importToken = "@import"
-	lParenToken = "("
-	rParenToken = ")"
-	lCurlyToken = "{"
-	rCurlyToken = "}"
+	importKeyword = "@import"
 )
 
-func sliceUntil(tokens []string, search string, start int, offset int) ([]string, error) {
+func sliceUntil(tokens []token, search tokenKind, start int, offset int) ([]token, error) {
+	if start < 0 || offset < 0 || start+offset < 0 {
+		return []token{}, fmt.Errorf("invalid start position: %d+%d", start, offset)
+	}
+
 	for i := start + offset; i < len(tokens); i++ {
-		if tokens[i] == search {
+		if tokens[i].kind == search {
 			return tokens[start : i+1], nil
 		}
 	}
 
-	return []string{}, fmt.Errorf("search token not found: %s", search)
+	return []token{}, fmt.Errorf("search token not found: %s", search)
 }
 
-func sliceUntilMatching(tokens []string, opener, closer string, start int, offset int) ([]string, error) {
+func sliceUntilMatching(tokens []token, opener, closer tokenKind, start int, offset int) ([]token, error) {
+	if start < 0 || offset < 0 || start+offset < 0 {
+		return []token{}, fmt.Errorf("invalid start position: %d+%d", start, offset)
+	}
+
 	open := 0
 	for i := start + offset; i < len(tokens); i++ {
-		if tokens[i] == opener {
+		if tokens[i].kind == opener {
 			open++
-		} else if tokens[i] == closer {
+		} else if tokens[i].kind == closer {
 			open--
 		}
 
@@ -36,5 +41,25 @@ func sliceUntilMatching(tokens []string, opener, closer string, start int, offse
 		}
 	}
 
-	return []string{}, fmt.Errorf("matching search token not found: %s", closer)
+	return []token{}, fmt.Errorf("matching search token not found: %s", closer)
+}
+
+// unquote returns the contents of a string, escaped string or url() token without the surrounding
+// quotes or url( ) wrapper.
+func unquote(t token) string {
+	str := t.value
+
+	switch t.kind {
+	case tokenEscape:
+		str = str[1:]
+
+	case tokenURL:
+		str = strings.TrimSpace(str[len("url(") : len(str)-1])
+	}
+
+	if len(str) >= 2 && (str[0] == '"' || str[0] == '\'') && str[len(str)-1] == str[0] {
+		str = str[1 : len(str)-1]
+	}
+
+	return str
 }
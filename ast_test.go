@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func FuzzSliceUntilMatching(f *testing.F) {
+	f.Add([]byte(`@import (reference) "a.less";`), 1, 0)
+	f.Add([]byte(`.a(@b; (@c + 1)) { d: e; }`), 1, 0)
+	f.Add([]byte(`((`), 0, 1)
+	f.Add([]byte(`a)`), 2, -2)
+
+	f.Fuzz(func(t *testing.T, in []byte, start, offset int) {
+		tokens := tokenize(in)
+
+		slice, err := sliceUntilMatching(tokens, tokenLParen, tokenRParen, start, offset)
+		if err != nil {
+			return
+		}
+
+		if len(slice) == 0 || &slice[0] != &tokens[start] {
+			t.Fatalf("slice doesn't begin at token %d", start)
+		}
+
+		open := 0
+		for _, tok := range slice[offset:] {
+			switch tok.kind {
+			case tokenLParen:
+				open++
+			case tokenRParen:
+				open--
+			}
+		}
+
+		if open != 0 {
+			t.Fatalf("slice %v isn't balanced", slice)
+		}
+	})
+}
+
+func FuzzSliceUntil(f *testing.F) {
+	f.Add([]byte(`@import "a.less";`), 0, 0)
+	f.Add([]byte(`a; b;`), 2, 0)
+	f.Add([]byte(`a;`), 5, -5)
+
+	f.Fuzz(func(t *testing.T, in []byte, start, offset int) {
+		tokens := tokenize(in)
+
+		slice, err := sliceUntil(tokens, tokenSemicolon, start, offset)
+		if err != nil {
+			return
+		}
+
+		if len(slice) == 0 || &slice[0] != &tokens[start] || slice[len(slice)-1].kind != tokenSemicolon {
+			t.Fatalf("slice %v isn't a statement starting at token %d", slice, start)
+		}
+	})
+}
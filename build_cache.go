@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Names of the outputs stored for each build in the shared cache.
+const (
+	artifactCSS    = "css"
+	artifactMinCSS = "min.css"
+	artifactMap    = "css.map"
+)
+
+var buildKeyPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// A buildCache is a content-addressed store of compiled outputs, shared between checkouts (and CI runs)
+// so the same stylesheet doesn't have to be compiled twice. Outputs are keyed by a hash of everything
+// that goes into the build (see buildKey) and named by one of the artifact constants.
+type buildCache interface {
+	// Get returns the stored output called name for the build key. The error satisfies os.IsNotExist
+	// if there isn't one.
+	Get(key, name string) ([]byte, error)
+
+	// Put stores an output for the build key.
+	Put(key, name string, contents []byte) error
+}
+
+// A dirCache is a buildCache stored in a local directory.
+type dirCache struct {
+	dir string
+}
+
+// A tieredCache checks each of its caches in order, copying anything it finds into the caches before
+// it. New builds are stored in all of them, even if some of them fail.
+type tieredCache []buildCache
+
+// A readOnlyCache is a buildCache that never stores anything.
+type readOnlyCache struct {
+	buildCache
+}
+
+// openSharedCache sets up the shared build cache from a local directory, a remote server, or both (in
+// which case the directory is checked first). mode is "read" to never upload to the server, or "write".
+// It returns nil if neither is configured.
+func openSharedCache(dir, url, mode string) (buildCache, error) {
+	caches := tieredCache{}
+
+	if dir != "" {
+		c, err := newDirCache(dir)
+		if err != nil {
+			return nil, err
+		}
+		caches = append(caches, c)
+	}
+
+	if url != "" {
+		var c buildCache = newHTTPCache(url)
+		switch mode {
+		case "read":
+			c = readOnlyCache{c}
+		case "write":
+		default:
+			return nil, fmt.Errorf("invalid cache mode %q (should be read or write)", mode)
+		}
+		caches = append(caches, c)
+	}
+
+	if len(caches) == 0 {
+		return nil, nil
+	}
+
+	return caches, nil
+}
+
+func newDirCache(dir string) (*dirCache, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache directory %s: %s", dir, err)
+	}
+
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("can't create cache directory %s: %s", abs, err)
+	}
+
+	return &dirCache{dir: abs}, nil
+}
+
+func (c *dirCache) path(key, name string) (string, error) {
+	if err := validateArtifact(key, name); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(c.dir, key[0:2], key, name), nil
+}
+
+func (c *dirCache) Get(key, name string) ([]byte, error) {
+	path, err := c.path(key, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// Put writes the output to a temporary file and renames it into place, so concurrent readers never see
+// a partial file.
+func (c *dirCache) Put(key, name string, contents []byte) error {
+	path, err := c.path(key, name)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, contents, 0644)
+}
+
+func (t tieredCache) Get(key, name string) ([]byte, error) {
+	var err error
+	for i, c := range t {
+		var contents []byte
+		contents, err = c.Get(key, name)
+		if err != nil {
+			continue
+		}
+
+		for _, prev := range t[:i] {
+			prev.Put(key, name, contents)
+		}
+
+		return contents, nil
+	}
+
+	return nil, err
+}
+
+func (t tieredCache) Put(key, name string, contents []byte) error {
+	errs := []string{}
+	for _, c := range t {
+		if err := c.Put(key, name, contents); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (c readOnlyCache) Put(key, name string, contents []byte) error {
+	return nil
+}
+
+// validateArtifact makes sure a build key and artifact name are safe to use in a path or url.
+func validateArtifact(key, name string) error {
+	if !buildKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid build key: %q", key)
+	}
+
+	switch name {
+	case artifactCSS, artifactMinCSS, artifactMap:
+		return nil
+	}
+
+	return fmt.Errorf("invalid artifact name: %q", name)
+}
+
+// writeFileAtomic writes contents to a temporary file next to path and renames it into place.
+func writeFileAtomic(path string, contents []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// buildKey hashes everything that determines the output of building an entry point: its name, its
+// contents and the contents of everything it imports, the variables passed to lessc and the toolchain.
+// Absolute paths are left out so the key is the same in every checkout.
+func buildKey(l *lessFile) string {
+	h := sha1.New()
+
+	fmt.Fprintf(h, "less-tree build\n")
+	fmt.Fprintf(h, "entry %s\n", l.Name)
+	for _, arg := range l.Vars.args() {
+		fmt.Fprintf(h, "var %s\n", arg)
+	}
+
+	if f := l.Fingerprint; f != nil {
+		fmt.Fprintf(h, "lessc %s\n", f.LesscVersion)
+		for _, arg := range f.Args {
+			fmt.Fprintf(h, "arg %s\n", arg)
+		}
+		if f.Minifier != "" {
+			fmt.Fprintf(h, "minifier %s\n", filepath.Base(f.Minifier))
+		}
+		fmt.Fprintf(h, "version %s\n", f.Version)
+	}
+
+	writeImportTree(h, l, 0)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeImportTree(w io.Writer, l *lessFile, depth int) {
+	fmt.Fprintf(w, "%d %s %s\n", depth, l.Name, l.Hash)
+	for _, imp := range l.Imports {
+		fmt.Fprintf(w, "%d options %q\n", depth, imp.Options)
+		writeImportTree(w, imp.File, depth+1)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildKey(t *testing.T) {
+	entry := func(dir string) *lessFile {
+		return &lessFile{
+			Name:        "a.less",
+			Path:        filepath.Join(dir, "less", "a.less"),
+			Hash:        "a",
+			Vars:        modifyVars{"color": "red"},
+			Fingerprint: &buildFingerprint{Lessc: filepath.Join(dir, "node_modules", ".bin", "lessc"), LesscVersion: "lessc 3.9.0", Version: "1.6.0"},
+			Imports: []*lessImport{
+				{File: &lessFile{Name: "_b.less", Path: filepath.Join(dir, "less", "_b.less"), Hash: "b"}},
+			},
+		}
+	}
+
+	key := buildKey(entry("/home/a/site"))
+	if !buildKeyPattern.MatchString(key) {
+		t.Fatalf("expected a valid build key, got %q", key)
+	}
+
+	if other := buildKey(entry("/srv/ci/checkout")); other != key {
+		t.Errorf("expected the same key in another checkout, got %s and %s", key, other)
+	}
+
+	changes := map[string]func(l *lessFile){
+		"name":          func(l *lessFile) { l.Name = "b.less" },
+		"hash":          func(l *lessFile) { l.Hash = "c" },
+		"vars":          func(l *lessFile) { l.Vars = modifyVars{"color": "blue"} },
+		"lessc version": func(l *lessFile) { l.Fingerprint.LesscVersion = "lessc 4.1.0" },
+		"args":          func(l *lessFile) { l.Fingerprint.Args = []string{"--ie-compat"} },
+		"minifier":      func(l *lessFile) { l.Fingerprint.Minifier = "/usr/bin/cssmin" },
+		"version":       func(l *lessFile) { l.Fingerprint.Version = "1.7.0" },
+		"import":        func(l *lessFile) { l.Imports[0].File.Hash = "c" },
+		"import option": func(l *lessFile) { l.Imports[0].Options = []string{"reference"} },
+	}
+
+	for name, change := range changes {
+		l := entry("/home/a/site")
+		change(l)
+
+		if buildKey(l) == key {
+			t.Errorf("expected a change to the %s to change the key", name)
+		}
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	c, err := newDirCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.Repeat("ab", 20)
+
+	if _, err := c.Get(key, artifactCSS); !os.IsNotExist(err) {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+
+	if err := c.Put(key, artifactCSS, []byte("h1{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, err := c.Get(key, artifactCSS); err != nil || !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected to get back what was stored, got %q, %v", contents, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.dir, "ab", key, artifactCSS)); err != nil {
+		t.Errorf("expected the output to be stored under its key's prefix: %s", err)
+	}
+
+	if err := c.Put("../../etc", artifactCSS, nil); err == nil {
+		t.Error("expected an invalid key to be rejected")
+	}
+	if _, err := c.Get(key, "../passwd"); err == nil {
+		t.Error("expected an invalid artifact name to be rejected")
+	}
+}
+
+// A failingCache fails to store anything.
+type failingCache struct {
+	buildCache
+}
+
+func (c failingCache) Put(key, name string, contents []byte) error {
+	return errors.New("disk full")
+}
+
+func TestTieredCache(t *testing.T) {
+	local, _ := newDirCache(t.TempDir())
+	remote, _ := newDirCache(t.TempDir())
+	key := strings.Repeat("ab", 20)
+
+	remote.Put(key, artifactCSS, []byte("h1{}"))
+
+	c := tieredCache{local, remote}
+	if contents, err := c.Get(key, artifactCSS); err != nil || !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected to get the remote output, got %q, %v", contents, err)
+	}
+	if contents, _ := local.Get(key, artifactCSS); !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected the remote output to be copied to the local cache, got %q", contents)
+	}
+
+	c = tieredCache{failingCache{local}, remote}
+	if err := c.Put(key, artifactMinCSS, []byte("h1{}")); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected the failure to be reported, got %v", err)
+	}
+	if contents, _ := remote.Get(key, artifactMinCSS); !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected the output to be stored in the next cache after one failed, got %q", contents)
+	}
+}
+
+func TestRestoreFromCache(t *testing.T) {
+	defer func(c buildCache, min bool) { sharedCache, enableCSSMin = c, min }(sharedCache, enableCSSMin)
+
+	root := t.TempDir()
+	for _, dir := range []string{"less", "css"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "less", "a.less"), []byte("h1{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, _ := newDirCache(t.TempDir())
+	sharedCache = cache
+	key := strings.Repeat("ab", 20)
+
+	newJob := func() *cssJob {
+		lessDir, _ := os.Open(filepath.Join(root, "less"))
+		cssDir, _ := os.Open(filepath.Join(root, "css"))
+		t.Cleanup(func() {
+			lessDir.Close()
+			cssDir.Close()
+		})
+
+		fi, err := os.Stat(filepath.Join(root, "less", "a.less"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		job := newCSSJob(context.Background(), "a.less", lessDir, cssDir, fi, nil, nil)
+		job.cacheKey = key
+		return job
+	}
+
+	enableCSSMin = false
+	if newJob().restoreFromCache() {
+		t.Fatal("expected nothing to be restored from an empty cache")
+	}
+
+	cache.Put(key, artifactCSS, []byte("h1{}"))
+	cache.Put(key, artifactMap, []byte("{}"))
+
+	enableCSSMin = true
+	if newJob().restoreFromCache() {
+		t.Fatal("expected nothing to be restored without the minified output, with -min")
+	}
+
+	enableCSSMin = false
+	job := newJob()
+	if !job.restoreFromCache() {
+		t.Fatal("expected the outputs to be restored")
+	}
+
+	css, err := ioutil.ReadFile(filepath.Join(root, "css", "a.css"))
+	if err != nil || !bytes.HasSuffix(css, []byte("h1{}")) || !bytes.HasPrefix(css, []byte("/* generated by less-tree")) {
+		t.Errorf("expected the CSS to be restored with a header, got %q, %v", css, err)
+	}
+	if sourceMap, err := ioutil.ReadFile(filepath.Join(root, "css", "a.css.map")); err != nil || string(sourceMap) != "{}" {
+		t.Errorf("expected the source map to be restored as it was, got %q, %v", sourceMap, err)
+	}
+	if rec := job.outputs["a.css"]; rec == nil || rec.Hash != hashOutput(css) || rec.Size != int64(len(css)) {
+		t.Errorf("expected the restored output's hash to be recorded, got %v", job.outputs)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["cache"] = &command{
+		args:        "show|why|clear|gc <dir> [...]",
+		description: "Inspect or clean up the cache for <dir>; run less-tree cache -h for details",
+		run:         cacheCommand,
+	}
+}
+
+func cacheCommand(args []string) int {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: less-tree [options] cache <command> <dir> [arguments]\n\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  show <dir> [pattern]\n    \tShow the cached entry points matching pattern, with their imports and hashes\n")
+		fmt.Fprintf(os.Stderr, "  why <dir> <file>\n    \tExplain whether the entry point file (relative to <dir>/less) will be rebuilt, and why\n")
+		fmt.Fprintf(os.Stderr, "  clear <dir> [pattern]\n    \tForget the entry points matching pattern (or all of them), so they're rebuilt next time\n")
+		fmt.Fprintf(os.Stderr, "  gc <dir>\n    \tForget the entry points whose LESS files no longer exist\n")
+	}
+	fs.Parse(args)
+
+	if err := loadState(); err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	switch {
+	case fs.NArg() == 2 && fs.Arg(0) == "show":
+		return cacheShow(fs.Arg(1), "")
+	case fs.NArg() == 3 && fs.Arg(0) == "show":
+		return cacheShow(fs.Arg(1), fs.Arg(2))
+	case fs.NArg() == 3 && fs.Arg(0) == "why":
+		return cacheWhy(fs.Arg(1), fs.Arg(2))
+	case fs.NArg() == 2 && fs.Arg(0) == "clear":
+		return cacheClear(fs.Arg(1), "")
+	case fs.NArg() == 3 && fs.Arg(0) == "clear":
+		return cacheClear(fs.Arg(1), fs.Arg(2))
+	case fs.NArg() == 2 && fs.Arg(0) == "gc":
+		return cacheGC(fs.Arg(1))
+	}
+
+	fs.Usage()
+	return exitEnvironment
+}
+
+// openCache loads the cache for the root dir. A missing cache isn't an error; it's just empty.
+func openCache(dir string) (*lessTreeCache, error) {
+	root, cssDir, _ := rootDirs(dir)
+
+	cm := newLessTreeCache(stateFor(root, cssDir).cache)
+	if err := cm.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return cm, nil
+}
+
+// lockCache locks the root dir and loads its cache, for commands that change it.
+func lockCache(dir string) (*lessTreeCache, *rootLock, error) {
+	root, cssDir, _ := rootDirs(dir)
+
+	lock, err := acquireLock(context.Background(), stateFor(root, cssDir).lock, cssDir, lockTimeout, !noWait)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cm, err := openCache(dir)
+	if err != nil {
+		lock.Release()
+		return nil, nil, err
+	}
+
+	return cm, lock, nil
+}
+
+// entryNames returns the names of the cache entries matching pattern (all of them if it's empty),
+// sorted.
+func (c *lessTreeCache) entryNames(pattern string) []string {
+	names := []string{}
+	for name := range c.Files {
+		if pattern == "" || matchGlob(pattern, filepath.ToSlash(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func cacheShow(dir, pattern string) int {
+	cm, err := openCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	if len(cm.Files) == 0 {
+		fmt.Printf("%s: the cache is empty\n", displayPath(cm.file))
+		return exitOK
+	}
+
+	names := cm.entryNames(pattern)
+	fmt.Printf("%s: %d of %d entry points, written by less-tree v%s at %s\n", displayPath(cm.file), len(names), len(cm.Files), cm.Version, cm.Generated.Format("2006-01-02 15:04:05"))
+
+	for _, name := range names {
+		f := cm.Files[name]
+
+		fmt.Printf("\n%s\n", f.prefixString(0))
+		if len(f.Vars) > 0 {
+			fmt.Printf("   vars: %s\n", f.Vars.String())
+		}
+		if f.Fingerprint != nil {
+			fmt.Printf("   lessc: %s %s\n", f.Fingerprint.Lessc, f.Fingerprint.LesscVersion)
+		}
+		if f.Duration > 0 {
+			fmt.Printf("   compiled in: %s\n", f.Duration)
+		}
+		for _, out := range sortedKeys(f.Outputs) {
+			fmt.Printf("   output: %s (%s)\n", out, f.Outputs[out].Hash)
+		}
+	}
+
+	return exitOK
+}
+
+func cacheWhy(dir, file string) int {
+	if err := validateEnvironment(); err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	root, cssDir, lessDir := rootDirs(dir)
+
+	// accept either a path relative to the less directory or one to the file itself
+	name := filepath.Clean(file)
+	if abs, err := filepath.Abs(file); err == nil {
+		if rel, err := filepath.Rel(lessDir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			if _, err := os.Stat(abs); err == nil {
+				name = rel
+			}
+		}
+	}
+
+	cm, err := openCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	path := filepath.Join(lessDir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		if _, cached := cm.Files[name]; cached {
+			fmt.Printf("%s doesn't exist any more; less-tree cache gc %s will forget it\n", name, dir)
+			return exitOK
+		}
+
+		fmt.Fprintf(os.Stderr, "less-tree: can't find %s\n", displayPath(path))
+		return exitEnvironment
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if strings.HasPrefix(part, "_") {
+			fmt.Printf("%s isn't an entry point, so it's only built as part of the files that import it\n", name)
+			return exitOK
+		}
+	}
+
+	lessFileDir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	l, err := newLessFile(name, lessFileDir, nil, fi, cm, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitCompile
+	}
+	l.Vars = cfg.root(root).varsFor(name, cmdVars)
+	l.Fingerprint = newBuildFingerprint()
+
+	fresh, reason := cm.Check(l)
+
+	modified := []string{}
+	if outDir, err := os.Open(filepath.Join(cssDir, filepath.Dir(name))); err == nil {
+		job := newCSSJob(context.Background(), name, lessFileDir, outDir, fi, lesscArgs.out, l.Vars)
+		if cached := cm.Files[name]; cached != nil {
+			modified = job.ModifiedOutputs(cached.Outputs, cm.Generated)
+		}
+		reason = rebuildReason(fresh, reason, job, modified)
+		outDir.Close()
+	} else if fresh {
+		reason = "output missing"
+	}
+
+	if reason == "" {
+		fmt.Printf("%s is up to date\n", name)
+	} else {
+		fmt.Printf("%s will be rebuilt: %s\n", name, reason)
+	}
+
+	for _, path := range modified {
+		fmt.Printf("%s has been changed since less-tree wrote it\n", displayPath(path))
+	}
+
+	return exitOK
+}
+
+func cacheClear(dir, pattern string) int {
+	cm, lock, err := lockCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+	defer lock.Release()
+
+	names := cm.entryNames(pattern)
+	if len(names) == 0 && pattern != "" {
+		fmt.Fprintf(os.Stderr, "less-tree: nothing in the cache matches %s\n", pattern)
+		return exitEnvironment
+	}
+
+	for _, name := range names {
+		delete(cm.Files, name)
+	}
+
+	if err := cm.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: can't save the cache: %s\n", err)
+		return exitInternal
+	}
+
+	fmt.Printf("cleared %d entry points\n", len(names))
+
+	return exitOK
+}
+
+func cacheGC(dir string) int {
+	cm, lock, err := lockCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+	defer lock.Release()
+
+	_, _, lessDir := rootDirs(dir)
+
+	removed := 0
+	for _, name := range cm.entryNames("") {
+		path := cm.Files[name].Path
+		if path == "" {
+			path = filepath.Join(lessDir, name)
+		}
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			fmt.Printf("removed: %s\n", name)
+			delete(cm.Files, name)
+			removed++
+		}
+	}
+
+	if err := cm.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: can't save the cache: %s\n", err)
+		return exitInternal
+	}
+
+	fmt.Printf("removed %d of %d entry points\n", removed, removed+len(cm.Files))
+
+	return exitOK
+}
+
+func sortedKeys(m map[string]*outputRecord) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
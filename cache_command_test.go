@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCacheTestRoot makes a root with two entry points, one of which imports an include, and a cache
+// that's up to date with it, as if it had just been built by an older version of less-tree.
+func newCacheTestRoot(t *testing.T) string {
+	t.Helper()
+
+	fakeLessc(t, `cat "$last"`)
+
+	root := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	files := map[string]string{
+		"less/a.less":    `@import "_inc"; .a { color: @color; }`,
+		"less/_inc.less": `@color: red;`,
+		"less/b.less":    `.b { color: blue; }`,
+		"css/a.css":      `.a { color: red; }`,
+		"css/b.css":      `.b { color: blue; }`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	crawler, err := newDirectoryCrawler(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := newLessTreeCache(filepath.Join(root, "css", ".less-tree-cache"))
+	analyzeRoot(context.Background(), crawler, cm, func(l *lessFile) {
+		l.Fingerprint = newBuildFingerprint()
+		cm.Test(l)
+	}, func(err error) {
+		t.Error(err)
+	})
+	if err := cm.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, _ := ioutil.ReadFile(cm.file)
+	contents = bytes.Replace(contents, []byte(`"version": "`+version+`"`), []byte(`"version": "0.9.0"`), 1)
+	if err := ioutil.WriteFile(cm.file, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+// runCacheCommand runs less-tree cache with args and returns its exit status and what it printed.
+func runCacheCommand(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan string)
+	go func() {
+		buf := &bytes.Buffer{}
+		io.Copy(buf, r)
+		out <- buf.String()
+	}()
+
+	prev := os.Stdout
+	os.Stdout = w
+	status := cacheCommand(args)
+	os.Stdout = prev
+	w.Close()
+
+	return status, <-out
+}
+
+func TestCacheShow(t *testing.T) {
+	root := newCacheTestRoot(t)
+
+	status, out := runCacheCommand(t, "show", root)
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d: %s", status, out)
+	}
+
+	for _, expected := range []string{"2 of 2 entry points, written by less-tree v0.9.0", "File a.less imports 1 files", " - File _inc ", "File b.less", "lessc 3.9.0"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+
+	status, out = runCacheCommand(t, "show", root, "b*")
+	if status != 0 || !strings.Contains(out, "1 of 2 entry points") || strings.Contains(out, "a.less") {
+		t.Errorf("expected only b.less, got %d:\n%s", status, out)
+	}
+}
+
+func TestCacheWhy(t *testing.T) {
+	root := newCacheTestRoot(t)
+
+	tests := []struct {
+		name     string
+		change   func()
+		file     string
+		expected string
+	}{
+		{"up to date", func() {}, "a.less", "a.less is up to date"},
+		{"include", func() {}, "_inc.less", "_inc.less isn't an entry point"},
+		{"import changed", func() {
+			ioutil.WriteFile(filepath.Join(root, "less", "_inc.less"), []byte(`@color: green;`), 0644)
+		}, "a.less", "a.less will be rebuilt: import _inc changed"},
+		{"output missing", func() { os.Remove(filepath.Join(root, "css", "b.css")) }, "b.less", "b.less will be rebuilt: output missing"},
+		{"deleted", func() { os.Remove(filepath.Join(root, "less", "b.less")) }, "b.less", "b.less doesn't exist any more"},
+	}
+
+	for _, test := range tests {
+		test.change()
+
+		status, out := runCacheCommand(t, "why", root, test.file)
+		if status != 0 || !strings.Contains(out, test.expected) {
+			t.Errorf("%s: expected %q, got %d: %s", test.name, test.expected, status, out)
+		}
+	}
+
+	if status, _ := runCacheCommand(t, "why", root, "missing.less"); status != exitEnvironment {
+		t.Errorf("expected a file that doesn't exist and isn't cached to exit with %d, got %d", exitEnvironment, status)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	root := newCacheTestRoot(t)
+
+	if status, _ := runCacheCommand(t, "clear", root, "c*"); status != exitEnvironment {
+		t.Errorf("expected a pattern that doesn't match anything to exit with %d, got %d", exitEnvironment, status)
+	}
+
+	status, out := runCacheCommand(t, "clear", root, "a*")
+	if status != 0 || !strings.Contains(out, "cleared 1 entry points") {
+		t.Fatalf("expected a.less to be cleared, got %d: %s", status, out)
+	}
+
+	cm := loadCacheTestRoot(t, root)
+	if _, exists := cm.Files["a.less"]; exists || len(cm.Files) != 1 {
+		t.Errorf("expected only b.less to be left, got %v", cm.entryNames(""))
+	}
+
+	if status, _ := runCacheCommand(t, "clear", root); status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if cm := loadCacheTestRoot(t, root); len(cm.Files) != 0 {
+		t.Errorf("expected the cache to be empty, got %v", cm.entryNames(""))
+	}
+}
+
+func TestCacheGC(t *testing.T) {
+	root := newCacheTestRoot(t)
+
+	if err := os.Remove(filepath.Join(root, "less", "b.less")); err != nil {
+		t.Fatal(err)
+	}
+
+	status, out := runCacheCommand(t, "gc", root)
+	if status != 0 || !strings.Contains(out, "removed: b.less") || !strings.Contains(out, "removed 1 of 2 entry points") {
+		t.Fatalf("expected b.less to be removed, got %d: %s", status, out)
+	}
+
+	cm := loadCacheTestRoot(t, root)
+	if _, exists := cm.Files["a.less"]; !exists || len(cm.Files) != 1 {
+		t.Errorf("expected only a.less to be left, got %v", cm.entryNames(""))
+	}
+}
+
+// TestCacheLocked checks that clear and gc refuse to change the cache of a root that another run has
+// locked, and say that's a problem with the environment.
+func TestCacheLocked(t *testing.T) {
+	defer func(wait bool) { noWait = wait }(noWait)
+	noWait = true
+
+	root := newCacheTestRoot(t)
+	cssDir := filepath.Join(root, "css")
+
+	lock, err := acquireLock(context.Background(), filepath.Join(cssDir, ".less-tree-lock"), cssDir, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	for _, args := range [][]string{{"clear", root}, {"gc", root}} {
+		if status, _ := runCacheCommand(t, args...); status != exitEnvironment {
+			t.Errorf("%s: expected a locked root to exit with %d, got %d", args[0], exitEnvironment, status)
+		}
+	}
+
+	if cm := loadCacheTestRoot(t, root); len(cm.Files) != 2 {
+		t.Errorf("expected the cache to be left alone, got %v", cm.entryNames(""))
+	}
+}
+
+func loadCacheTestRoot(t *testing.T, root string) *lessTreeCache {
+	t.Helper()
+
+	cm := newLessTreeCache(filepath.Join(root, "css", ".less-tree-cache"))
+	if err := cm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return cm
+}
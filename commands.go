@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A command is a subcommand of less-tree, like lint. Subcommands are dispatched on the first argument
+// after the global options (see subcommand) and get the rest of the arguments to parse however they like.
+type command struct {
+	args        string
+	description string
+	run         func(args []string) int
+}
+
+var commands = map[string]*command{}
+
+// subcommand returns the command named arg, or nil if there isn't one. A directory with the same name
+// as a command wins, so less-tree lint still builds a root called lint, like it did before there were
+// any commands; run the command from somewhere else to get at it.
+func subcommand(arg string) *command {
+	cmd, exists := commands[arg]
+	if !exists {
+		return nil
+	}
+
+	if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+		return nil
+	}
+
+	return cmd
+}
+
+func commandUsage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Commands:\n")
+	for _, name := range names {
+		fmt.Printf("  %s %s\n    \t%s\n", name, commands[name].args, commands[name].description)
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubcommand(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if subcommand("lint") != commands["lint"] || subcommand("lint") == nil {
+		t.Error("expected lint to run the lint command")
+	}
+	if subcommand("public") != nil {
+		t.Error("expected public not to be a command")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "lint"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if subcommand("lint") != nil {
+		t.Error("expected a directory called lint to be built rather than running the command")
+	}
+}
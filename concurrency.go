@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A maxJobsFlag is -max-jobs, which is either a number of jobs or auto.
+type maxJobsFlag struct {
+	n    int
+	auto bool
+}
+
+// A jobLimiter limits how many jobs run at once, on top of the worker's own limit. acquire blocks until
+// another job can start.
+type jobLimiter interface {
+	acquire()
+	release()
+}
+
+// limiters is a jobLimiter made of several, all of which have to let a job start.
+type limiters []jobLimiter
+
+// An adaptiveLimiter is -max-jobs=auto. It starts at half the maximum and measures how many jobs finish
+// per second: while adding jobs makes them finish faster it keeps adding them, and once it doesn't it
+// backs off. Every lessc is a Node process of its own, so it also backs off when memory runs low, and
+// doesn't add jobs without room for another one.
+type adaptiveLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running int
+	limit   int
+	max     int
+
+	// memory returns the available and total memory, in bytes, if it can be measured.
+	memory func() (available, total uint64, ok bool)
+
+	windowStart time.Time
+	finished    int
+	lastRate    float64
+	step        int
+}
+
+// jobMemory is roughly how much memory a lessc process needs, for deciding whether there's room for
+// another one.
+const jobMemory = 256 << 20
+
+func (m *maxJobsFlag) String() string {
+	if m.auto {
+		return "auto"
+	}
+	return strconv.Itoa(m.n)
+}
+
+func (m *maxJobsFlag) Set(in string) error {
+	if in == "auto" {
+		m.auto = true
+		return nil
+	}
+
+	n, err := strconv.Atoi(in)
+	if err != nil || n < 1 {
+		return fmt.Errorf("should be a number of jobs or auto")
+	}
+
+	m.n, m.auto = n, false
+	return nil
+}
+
+// slots is how many jobs the worker should be able to run at once. With auto, that's as many as the
+// adaptive limiter might allow.
+func (m *maxJobsFlag) slots() int {
+	if m.auto {
+		return 2 * runtime.NumCPU()
+	}
+	return m.n
+}
+
+// newJobLimiter returns the limiter for the run: an adaptive one for -max-jobs=auto, and make's
+// jobserver if less-tree was started by make -j. It returns nil if the worker's limit is all there is.
+func newJobLimiter(m maxJobsFlag, makeflags string) jobLimiter {
+	l := limiters{}
+
+	if m.auto {
+		l = append(l, newAdaptiveLimiter(m.slots(), memoryStatus))
+	}
+
+	if auth := parseJobserverAuth(makeflags); auth != "" {
+		js, err := openJobserver(auth)
+		if err != nil {
+			log.warnf("can't use make's jobserver (%s), so this might run more jobs than make -j allows", err)
+		} else if js != nil {
+			log.debugf("jobs: using make's jobserver")
+			l = append(l, js)
+		}
+	}
+
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+
+	return l
+}
+
+func (l limiters) acquire() {
+	for _, v := range l {
+		v.acquire()
+	}
+}
+
+func (l limiters) release() {
+	for i := len(l) - 1; i >= 0; i-- {
+		l[i].release()
+	}
+}
+
+// parseJobserverAuth finds the jobserver that GNU make passes to the commands it runs in MAKEFLAGS, as
+// --jobserver-auth (or --jobserver-fds, before make 4.2). It returns "" if there isn't one.
+func parseJobserverAuth(makeflags string) string {
+	auth := ""
+	for _, field := range strings.Fields(makeflags) {
+		for _, prefix := range []string{"--jobserver-auth=", "--jobserver-fds="} {
+			if strings.HasPrefix(field, prefix) {
+				auth = strings.TrimPrefix(field, prefix)
+			}
+		}
+	}
+
+	return auth
+}
+
+func newAdaptiveLimiter(max int, memory func() (uint64, uint64, bool)) *adaptiveLimiter {
+	l := &adaptiveLimiter{
+		limit:  (max + 1) / 2,
+		max:    max,
+		memory: memory,
+		step:   1,
+	}
+	l.cond = sync.NewCond(&l.mu)
+
+	log.debugf("jobs: auto, starting with %d (up to %d)", l.limit, l.max)
+
+	return l
+}
+
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.running >= l.limit {
+		l.cond.Wait()
+	}
+
+	if l.windowStart.IsZero() {
+		l.windowStart = time.Now()
+	}
+	l.running++
+}
+
+func (l *adaptiveLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.running--
+	l.finished++
+
+	// Each measurement waits for every job to have finished about twice, so the rate isn't just down
+	// to which files happened to be running.
+	if l.finished >= 2*l.limit {
+		l.adjust(time.Now())
+	}
+
+	l.cond.Broadcast()
+}
+
+// adjust moves the limit a step, based on the rate jobs have finished at since the last adjustment.
+func (l *adaptiveLimiter) adjust(now time.Time) {
+	elapsed := now.Sub(l.windowStart).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	rate := float64(l.finished) / elapsed
+	previous := l.limit
+
+	available, total, ok := l.memory()
+	switch {
+	case ok && available < total/10:
+		l.step = -1
+	case rate < l.lastRate*0.95:
+		// the last step made things slower, so turn round
+		l.step = -l.step
+	}
+
+	l.limit += l.step
+	if l.step > 0 && ok && available < jobMemory {
+		l.limit = previous
+	}
+	if l.limit < 1 {
+		l.limit = 1
+	}
+	if l.limit > l.max {
+		l.limit = l.max
+	}
+
+	if l.limit != previous {
+		log.with("jobs", l.limit).debugf("jobs: %d (%.1f finished per second with %d)", l.limit, rate, previous)
+	}
+
+	l.lastRate = rate
+	l.windowStart = now
+	l.finished = 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseJobserverAuth(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "",
+		"-j8":                                "",
+		" -j8 --jobserver-auth=3,4":          "3,4",
+		"w -j --jobserver-fds=5,6":           "5,6",
+		"-j4 --jobserver-auth=fifo:/tmp/GM1": "fifo:/tmp/GM1",
+	}
+
+	for makeflags, expected := range tests {
+		if auth := parseJobserverAuth(makeflags); auth != expected {
+			t.Errorf("%q: expected %q, got %q", makeflags, expected, auth)
+		}
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	memory := struct{ available, total uint64 }{8 << 30, 16 << 30}
+	l := newAdaptiveLimiter(8, func() (uint64, uint64, bool) {
+		return memory.available, memory.total, true
+	})
+	if l.limit != 4 {
+		t.Fatalf("expected to start with 4 jobs, got %d", l.limit)
+	}
+
+	start := time.Now()
+	measure := func(finished int, seconds float64) {
+		l.windowStart = start
+		l.finished = finished
+		l.adjust(start.Add(time.Duration(seconds * float64(time.Second))))
+	}
+
+	// going faster keeps adding jobs
+	measure(8, 4)
+	measure(10, 4)
+	if l.limit != 6 {
+		t.Fatalf("expected 6 jobs while it's getting faster, got %d", l.limit)
+	}
+
+	// and going slower turns round
+	measure(6, 4)
+	if l.limit != 5 {
+		t.Fatalf("expected 5 jobs after it got slower, got %d", l.limit)
+	}
+
+	// and it backs off when memory runs low
+	l.step = 1
+	memory.available = jobMemory / 2
+	measure(100, 1)
+	if l.limit != 4 {
+		t.Fatalf("expected to back off to 4 jobs when memory is low, got %d", l.limit)
+	}
+
+	memory.available = memory.total / 2
+	for i := 0; i < 10; i++ {
+		measure(10*(i+1), 1)
+	}
+	if l.limit != 8 {
+		t.Fatalf("expected to stop at the maximum of 8, got %d", l.limit)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultConfigPath = "less-tree.json"
+
+// A config holds per-root and per-entry settings, read from a JSON file in the working directory, e.g.:
+//
+//	{
+//		"stateDir": ".cache/less-tree",
+//		"roots": {
+//			"public": {
+//				"vars": { "brand-primary": "#c00" },
+//				"entries": {
+//					"admin/**": { "vars": { "brand-primary": "#333" } }
+//				}
+//			}
+//		}
+//	}
+type config struct {
+	// StateDir is where to keep each root's cache file, like -state-dir. A relative path is relative to
+	// the config file.
+	StateDir string                 `json:"stateDir,omitempty"`
+	Roots    map[string]*rootConfig `json:"roots"`
+}
+
+type rootConfig struct {
+	Vars    map[string]string       `json:"vars,omitempty"`
+	Entries map[string]*entryConfig `json:"entries,omitempty"`
+}
+
+type entryConfig struct {
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+type modifyVars map[string]string
+
+// loadConfig reads the config file at path. A missing file is only an error if the path was given
+// explicitly.
+func loadConfig(path string, explicit bool) (*config, error) {
+	c := &config{
+		Roots: map[string]*rootConfig{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return c, nil
+		}
+		return nil, fmt.Errorf("can't read config file %s: %s", path, err)
+	}
+
+	err = json.Unmarshal(contents, c)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse config file %s: %s", path, err)
+	}
+
+	if c.StateDir != "" && !filepath.IsAbs(c.StateDir) {
+		c.StateDir = filepath.Join(filepath.Dir(path), c.StateDir)
+	}
+
+	return c, nil
+}
+
+// loadGlobalConfig reads the config file given by -config, or the default one in the working directory
+// if there is one.
+func loadGlobalConfig() (*config, error) {
+	if configPath != "" {
+		return loadConfig(configPath, true)
+	}
+
+	return loadConfig(filepath.Join(workingDirectory, defaultConfigPath), false)
+}
+
+// root returns the settings for the root directory dir, or empty settings if there aren't any.
+func (c *config) root(dir string) *rootConfig {
+	abs, _ := filepath.Abs(dir)
+
+	for k, v := range c.Roots {
+		if p, _ := filepath.Abs(k); p == abs && v != nil {
+			return v
+		}
+	}
+
+	return &rootConfig{}
+}
+
+// varsFor returns the variables to pass to lessc for the entry point name (relative to the less root).
+// Root variables are overridden by any matching entry patterns, which are in turn overridden by the
+// variables given on the command line. When several patterns match, the most specific one wins (see
+// patternSpecificity), and patterns that are as specific as each other are applied in alphabetical order.
+func (r *rootConfig) varsFor(name string, overrides modifyVars) modifyVars {
+	vars := modifyVars{}
+	for k, v := range r.Vars {
+		vars[k] = v
+	}
+
+	patterns := []string{}
+	for pattern := range r.Entries {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		a, b := patternSpecificity(patterns[i]), patternSpecificity(patterns[j])
+		if a != b {
+			return a < b
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	for _, pattern := range patterns {
+		if r.Entries[pattern] != nil && matchGlob(pattern, filepath.ToSlash(name)) {
+			for k, v := range r.Entries[pattern].Vars {
+				vars[k] = v
+			}
+		}
+	}
+
+	for k, v := range overrides {
+		vars[k] = v
+	}
+
+	if len(vars) == 0 {
+		return nil
+	}
+
+	return vars.normalize()
+}
+
+// patternSpecificity is how specific an entry pattern is: the number of characters in it that have to be
+// matched literally, so "admin/login.less" beats "admin/*.less", which beats "admin/**".
+func patternSpecificity(pattern string) int {
+	n := 0
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '*' || c == '?':
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			n++
+		default:
+			n++
+		}
+	}
+
+	return n
+}
+
+func (v *modifyVars) String() string {
+	pairs := []string{}
+	for _, arg := range v.args() {
+		pairs = append(pairs, strings.TrimPrefix(arg, "--modify-var="))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v *modifyVars) Set(in string) error {
+	i := strings.Index(in, "=")
+	if i <= 0 {
+		return fmt.Errorf("variables should be formatted as name=value")
+	}
+
+	if *v == nil {
+		*v = modifyVars{}
+	}
+	(*v)[strings.TrimPrefix(in[:i], "@")] = in[i+1:]
+
+	return nil
+}
+
+// normalize strips the leading @ lessc doesn't want from variable names.
+func (v modifyVars) normalize() modifyVars {
+	out := modifyVars{}
+	for k, val := range v {
+		out[strings.TrimPrefix(k, "@")] = val
+	}
+	return out
+}
+
+// args returns the variables as lessc arguments, sorted by name so they're stable between runs.
+func (v modifyVars) args() []string {
+	names := []string{}
+	for k := range v {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	args := []string{}
+	for _, k := range names {
+		args = append(args, fmt.Sprintf("--modify-var=%s=%s", k, v[k]))
+	}
+
+	return args
+}
+
+func (v modifyVars) equal(other modifyVars) bool {
+	if len(v) != len(other) {
+		return false
+	}
+
+	for k, val := range v {
+		if otherVal, exists := other[k]; !exists || otherVal != val {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVarsFor(t *testing.T) {
+	r := &rootConfig{
+		Vars: modifyVars{"color": "red", "@width": "10px"},
+		Entries: map[string]*entryConfig{
+			"admin/**":         {Vars: modifyVars{"color": "blue", "height": "1px"}},
+			"admin/*.less":     {Vars: modifyVars{"color": "green"}},
+			"admin/login.less": {Vars: modifyVars{"color": "black"}},
+			"**/*.less":        {Vars: modifyVars{"height": "2px"}},
+			"empty/**":         nil,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		overrides modifyVars
+		expected  modifyVars
+	}{
+		{"site.less", nil, modifyVars{"color": "red", "width": "10px", "height": "2px"}},
+		{"admin/login.less", nil, modifyVars{"color": "black", "width": "10px", "height": "1px"}},
+		{"admin/users.less", nil, modifyVars{"color": "green", "width": "10px", "height": "1px"}},
+		{"admin/reports/sales.less", nil, modifyVars{"color": "blue", "width": "10px", "height": "1px"}},
+		{"admin/login.less", modifyVars{"color": "white"}, modifyVars{"color": "white", "width": "10px", "height": "1px"}},
+		{"empty/site.less", nil, modifyVars{"color": "red", "width": "10px", "height": "2px"}},
+	}
+
+	for _, test := range tests {
+		actual := r.varsFor(test.name, test.overrides)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("varsFor(%q, %v): expected %v, got %v", test.name, test.overrides, test.expected, actual)
+		}
+	}
+
+	if vars := (&rootConfig{}).varsFor("site.less", nil); vars != nil {
+		t.Errorf("expected no variables without any config, got %v", vars)
+	}
+}
+
+func TestPatternSpecificity(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected int
+	}{
+		{"**", 0},
+		{"admin/**", 6},
+		{"admin/*.less", 11},
+		{"admin/[ab]?.less", 11},
+		{`admin/\*.less`, 12},
+		{"admin/login.less", 16},
+	}
+
+	for _, test := range tests {
+		if actual := patternSpecificity(test.pattern); actual != test.expected {
+			t.Errorf("patternSpecificity(%q): expected %d, got %d", test.pattern, test.expected, actual)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := loadConfig(filepath.Join(dir, defaultConfigPath), false)
+	if err != nil {
+		t.Fatalf("a missing config file that wasn't asked for shouldn't be an error: %s", err)
+	}
+	if len(c.Roots) != 0 || c.StateDir != "" {
+		t.Errorf("expected an empty config, got %+v", c)
+	}
+
+	if _, err := loadConfig(filepath.Join(dir, defaultConfigPath), true); err == nil {
+		t.Error("expected an error for a missing config file given with -config")
+	}
+
+	path := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(path, []byte(`{"roots": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path, true); err == nil {
+		t.Error("expected an error for a config file that isn't valid JSON")
+	}
+
+	path = filepath.Join(dir, defaultConfigPath)
+	err = ioutil.WriteFile(path, []byte(`{
+		"stateDir": ".cache/less-tree",
+		"roots": {
+			"public": {
+				"vars": { "brand-primary": "#c00" },
+				"entries": {
+					"admin/**": { "vars": { "brand-primary": "#333" } }
+				}
+			}
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := filepath.Join(dir, ".cache", "less-tree"); c.StateDir != expected {
+		t.Errorf("expected the state dir to be relative to the config file (%s), got %s", expected, c.StateDir)
+	}
+
+	r := c.Roots["public"]
+	if r == nil {
+		t.Fatalf("expected a config for public, got %+v", c.Roots)
+	}
+	if vars := r.varsFor("admin/users.less", nil); vars["brand-primary"] != "#333" {
+		t.Errorf("expected the admin/** variables for admin/users.less, got %v", vars)
+	}
+	if vars := r.varsFor("site.less", nil); vars["brand-primary"] != "#c00" {
+		t.Errorf("expected the root variables for site.less, got %v", vars)
+	}
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -16,31 +22,55 @@ var headerTemplate = template.Must(template.New("header").Parse(`/* generated by
 type cssJob struct {
 	Name string
 
+	ctx context.Context
+
 	LESSDir  *os.File
 	CSSDir   *os.File
 	LESSFile os.FileInfo
 
 	lesscArgs []string
+	vars      modifyVars
 
 	lessIn    string
 	cssOut    string
 	cssMinOut string
+	mapOut    string
 	lessHash  string
 
+	cacheKey  string
+	restored  bool
+	cssResult []byte
+	minResult []byte
+
+	// outputs are the CSS files written, as stored in lessFile.Outputs.
+	outputs map[string]*outputRecord
+
 	cmd    *exec.Cmd
 	cmdMin *exec.Cmd
 
-	exitCode int
+	exitCode  int
+	internal  bool
+	cancelled bool
+	timedOut  bool
+	duration  time.Duration
+
+	// expected is how long the job took last time, if it's been built before.
+	expected time.Duration
+
+	// finished is called, if it's set, once the job's done, however it went.
+	finished func()
 }
 
-func newCSSJob(name string, lessDir, cssDir *os.File, file os.FileInfo, lesscArgs []string) *cssJob {
+func newCSSJob(ctx context.Context, name string, lessDir, cssDir *os.File, file os.FileInfo, lesscArgs []string, vars modifyVars) *cssJob {
 
 	c := &cssJob{}
 	c.Name = name
+	c.ctx = ctx
 	c.LESSDir = lessDir
 	c.CSSDir = cssDir
 	c.LESSFile = file
 	c.lesscArgs = lesscArgs
+	c.vars = vars
 
 	c.init()
 
@@ -50,11 +80,13 @@ func newCSSJob(name string, lessDir, cssDir *os.File, file os.FileInfo, lesscArg
 func (j *cssJob) init() {
 	j.lessIn = j.LESSDir.Name() + string(os.PathSeparator) + j.LESSFile.Name()
 	j.cssOut, j.cssMinOut = j.getCSSFilename(false), j.getCSSFilename(true)
+	j.mapOut = j.cssOut + ".map"
 
 	lesscArgs := []string{}
 	if len(j.lesscArgs) > 0 {
 		lesscArgs = append(lesscArgs, j.lesscArgs...)
 	}
+	lesscArgs = append(lesscArgs, j.vars.args()...)
 	lesscArgs = append(lesscArgs, j.lessIn)
 
 	j.cmd = exec.Command(pathToLessc, lesscArgs...)
@@ -82,6 +114,47 @@ func (j *cssJob) OutputFilesExist() bool {
 	return exists
 }
 
+// ModifiedOutputs returns the paths of the CSS files whose contents don't match the hashes recorded when
+// they were last written. Missing files don't count. Like the LESS files, outputs whose size and
+// modification time are what was recorded aren't hashed again, unless they were modified within
+// racyWindow of when the record was saved, or with -paranoid.
+func (j *cssJob) ModifiedOutputs(recorded map[string]*outputRecord, saved time.Time) []string {
+	modified := []string{}
+	for name, rec := range recorded {
+		if rec == nil {
+			continue
+		}
+
+		path := filepath.Join(j.CSSDir.Name(), name)
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if !paranoid && rec.Size == fi.Size() && rec.ModTime.Equal(fi.ModTime()) && rec.ModTime.Before(saved.Add(-racyWindow)) {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		if hashOutput(contents) != rec.Hash {
+			modified = append(modified, path)
+		}
+	}
+	sort.Strings(modified)
+
+	return modified
+}
+
+func hashOutput(contents []byte) string {
+	sum := sha1.Sum(contents)
+	return hex.EncodeToString(sum[:])
+}
+
 func (j *cssJob) getCSSFilename(min bool) (css string) {
 	lessFilename := j.LESSFile.Name()
 	cssFilename := ""
@@ -102,39 +175,153 @@ func (j *cssJob) getCSSFilename(min bool) (css string) {
 	return path.Join(j.CSSDir.Name(), cssFilename)
 }
 
-func (j *cssJob) buildCSSOutput() error {
-	result, err := j.cmd.CombinedOutput()
-	if err != nil {
+func (j *cssJob) buildCSSOutput(ctx context.Context, span *traceSpan) error {
+	step := span.child("lessc", j.Name)
+	result, err := runCommand(ctx, j.cmd, true)
+	step.end()
+	if err == ctx.Err() && err != nil {
+		return err
+	} else if err != nil {
 		return lessError{Message: bytes.NewBuffer(result).String(), indent: 3}
 	}
 
-	destFile, err := os.OpenFile(j.cssOut, os.O_RDWR+os.O_TRUNC+os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("File write error: %s\n", err)
-	}
+	j.cssResult = result
 
-	err = j.writeOutput(result, destFile, true)
-	return err
+	return j.writeOutput(j.cssOut, result, true)
 }
 
-func (j *cssJob) buildMinCSSOutput() error {
-	result, err := j.cmdMin.Output()
-	if err != nil {
+func (j *cssJob) buildMinCSSOutput(ctx context.Context, span *traceSpan) error {
+	step := span.child("cssmin", j.Name)
+	result, err := runCommand(ctx, j.cmdMin, false)
+	step.end()
+	if err == ctx.Err() && err != nil {
+		return err
+	} else if err != nil {
 		return lessError{Message: bytes.NewBuffer(result).String(), indent: 3}
 	}
 
-	destFile, err := os.OpenFile(j.cssMinOut, os.O_RDWR+os.O_TRUNC+os.O_CREATE, 0644)
+	j.minResult = result
+
+	return j.writeOutput(j.cssMinOut, result, true)
+}
+
+// runCommand runs cmd and returns its output, like cmd.Output (or cmd.CombinedOutput if combined is
+// set). If ctx is cancelled first, cmd and anything it started are killed and ctx's error is returned.
+func runCommand(ctx context.Context, cmd *exec.Cmd, combined bool) ([]byte, error) {
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if combined {
+		cmd.Stderr = stdout
+	}
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	killed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+			killed <- true
+		case <-done:
+			killed <- false
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	// Only a process that was killed is cancelled: one that exited on its own, even while ctx was being
+	// cancelled, still reports how it went.
+	if <-killed && !exitedOnItsOwn(cmd.ProcessState) {
+		return nil, ctx.Err()
+	}
+
+	return stdout.Bytes(), err
+}
+
+// restoreFromCache writes the outputs stored in the shared cache for this job's build key, if there are
+// any. It returns false if anything is missing, in which case the job needs to be built as usual.
+func (j *cssJob) restoreFromCache() bool {
+	if sharedCache == nil || j.cacheKey == "" {
+		return false
+	}
+
+	css, err := sharedCache.Get(j.cacheKey, artifactCSS)
 	if err != nil {
-		return fmt.Errorf("File write error: %s\n", err)
+		return false
+	}
+
+	var min []byte
+	if j.cmdMin != nil {
+		min, err = sharedCache.Get(j.cacheKey, artifactMinCSS)
+		if err != nil {
+			return false
+		}
+	}
+
+	sourceMap, _ := sharedCache.Get(j.cacheKey, artifactMap)
+
+	outputs := []struct {
+		path     string
+		contents []byte
+		header   bool
+	}{
+		{j.cssOut, css, true},
+		{j.cssMinOut, min, true},
+		{j.mapOut, sourceMap, false},
+	}
+
+	for _, out := range outputs {
+		if out.contents == nil {
+			continue
+		}
+
+		if err := j.writeOutput(out.path, out.contents, out.header); err != nil {
+			return false
+		}
 	}
 
-	err = j.writeOutput(result, destFile, true)
-	return err
+	return true
 }
 
-func (j *cssJob) writeOutput(contents []byte, fp *os.File, includeHeader bool) error {
+// saveToCache stores the outputs of a successful build in the shared cache. The CSS is stored last, since
+// its presence is what marks an entry as complete.
+func (j *cssJob) saveToCache(started time.Time) error {
+	if sharedCache == nil || j.cacheKey == "" {
+		return nil
+	}
+
+	if fi, err := os.Stat(j.mapOut); err == nil && !fi.ModTime().Before(started) {
+		sourceMap, err := ioutil.ReadFile(j.mapOut)
+		if err != nil {
+			return err
+		}
+
+		if err := sharedCache.Put(j.cacheKey, artifactMap, sourceMap); err != nil {
+			return err
+		}
+	}
+
+	if j.minResult != nil {
+		if err := sharedCache.Put(j.cacheKey, artifactMinCSS, j.minResult); err != nil {
+			return err
+		}
+	}
+
+	return sharedCache.Put(j.cacheKey, artifactCSS, j.cssResult)
+}
+
+// writeOutput writes contents to the file at path, after the header if includeHeader is set. The file is
+// replaced atomically, so it's never left half-written. The hashes of the CSS outputs are recorded as
+// they're written.
+func (j *cssJob) writeOutput(path string, contents []byte, includeHeader bool) error {
+	buf := &bytes.Buffer{}
 	if includeHeader {
-		headerTemplate.Execute(fp, struct {
+		headerTemplate.Execute(buf, struct {
 			Date    string
 			Hash    string
 			Version string
@@ -145,37 +332,101 @@ func (j *cssJob) writeOutput(contents []byte, fp *os.File, includeHeader bool) e
 		})
 	}
 
-	fp.Write(contents)
+	buf.Write(contents)
+
+	if err := writeFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("File write error: %s\n", err)
+	}
+
+	if path == j.cssOut || path == j.cssMinOut {
+		rec := &outputRecord{Hash: hashOutput(buf.Bytes())}
+		if fi, err := os.Stat(path); err == nil {
+			rec.Size, rec.ModTime = fi.Size(), fi.ModTime()
+		}
+
+		if j.outputs == nil {
+			j.outputs = map[string]*outputRecord{}
+		}
+		j.outputs[filepath.Base(path)] = rec
+	}
+
 	return nil
 }
 
 func (j *cssJob) Run() {
+	if j.finished != nil {
+		defer j.finished()
+	}
 
 	var err error
 
-	if isVerbose {
-		fmt.Printf("build: %s\n", j.Name)
+	if j.ctx.Err() != nil {
+		j.cancelled = true
+		j.exitCode = 1
+		return
+	}
+
+	if j.restoreFromCache() {
+		j.restored = true
+
+		log.file(j.Name).verbosef("restored: %s", j.Name)
+		return
+	}
+
+	log.file(j.Name).verbosef("build: %s", j.Name)
+
+	started := time.Now()
+
+	ctx := j.ctx
+	if jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(j.ctx, jobTimeout)
+		defer cancel()
 	}
 
-	err = j.buildCSSOutput()
+	// lessc and cssmin are traced as steps of the job, so they're shown together
+	span := trace.begin("compile", "compile", j.Name)
+	step := "lessc"
+	err = j.buildCSSOutput(ctx, span)
 	if err == nil && j.cmdMin != nil {
-		err = j.buildMinCSSOutput()
+		step = "cssmin"
+		err = j.buildMinCSSOutput(ctx, span)
+	}
+	span.end()
+
+	if err != nil && err == ctx.Err() && j.ctx.Err() != nil {
+		log.file(j.Name).verbosef("cancelled: %s", j.Name)
+		j.cancelled = true
+		j.exitCode = 1
+		return
+	}
+
+	if err != nil && err == ctx.Err() {
+		log.file(j.Name).with("step", step).errorf("%s: %s timed out after %s", j.Name, step, jobTimeout)
+		j.timedOut = true
+		j.exitCode = 1
+		return
 	}
 
 	if err != nil {
 		switch err.(type) {
 		case lessError:
-			fmt.Printf("err: %s\n%s", j.Name, err)
+			log.file(j.Name).with("step", step).with("error", err).errorf("%s\n%s", j.Name, err)
 			j.exitCode = 1
 			return
 		default:
-			fmt.Printf("err: %s: %s", j.Name, err)
+			log.file(j.Name).with("step", step).with("error", err).errorf("%s: %s", j.Name, err)
+			j.internal = true
 			j.exitCode = 1
 			return
 		}
 	}
 
-	if isVerbose {
-		fmt.Printf("ok: %s\n", j.Name)
+	j.duration = time.Since(started)
+
+	if err := j.saveToCache(started); err != nil {
+		log.file(j.Name).warnf("%s: can't save to the shared cache: %s", j.Name, err)
 	}
+
+	log.file(j.Name).with("duration", j.duration).verbosef("ok: %s", j.Name)
 }
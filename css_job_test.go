@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestModifiedOutputs(t *testing.T) {
+	dir := t.TempDir()
+	cssDir, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cssDir.Close()
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name, contents := range map[string]string{"a.css": "a{}", "a.min.css": "edited", "b.css": "b{}"} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(contents), 0644)
+		os.Chtimes(path, old, old)
+	}
+
+	job := &cssJob{CSSDir: cssDir}
+	recorded := map[string]*outputRecord{
+		"a.css":       {Hash: hashOutput([]byte("a{}"))},
+		"a.min.css":   {Hash: hashOutput([]byte("a{ }"))},
+		"missing.css": {Hash: hashOutput([]byte("b{}"))},
+
+		// b.css has been edited too, but its size and modification time are what was recorded, so it
+		// isn't hashed again unless it has to be
+		"b.css": {Hash: hashOutput([]byte("c{}")), Size: 3, ModTime: old},
+	}
+	saved := time.Now()
+
+	if expected, modified := []string{filepath.Join(dir, "a.min.css")}, job.ModifiedOutputs(recorded, saved); !reflect.DeepEqual(modified, expected) {
+		t.Errorf("expected %v, got %v", expected, modified)
+	}
+
+	expected := []string{filepath.Join(dir, "a.min.css"), filepath.Join(dir, "b.css")}
+
+	// recorded just before the cache was saved, so the modification time can't be trusted
+	if modified := job.ModifiedOutputs(recorded, old.Add(racyWindow/2)); !reflect.DeepEqual(modified, expected) {
+		t.Errorf("within the racy window, expected %v, got %v", expected, modified)
+	}
+
+	paranoid = true
+	defer func() { paranoid = false }()
+	if modified := job.ModifiedOutputs(recorded, saved); !reflect.DeepEqual(modified, expected) {
+		t.Errorf("with -paranoid, expected %v, got %v", expected, modified)
+	}
+}
+
+func TestOutputRecordJSON(t *testing.T) {
+	outputs := map[string]*outputRecord{}
+	err := json.Unmarshal([]byte(`{"a.css": "abc", "a.min.css": {"hash": "def", "size": 3, "modTime": "2020-01-02T03:04:05Z"}}`), &outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := outputs["a.css"]; rec == nil || rec.Hash != "abc" || rec.Size != 0 || !rec.ModTime.IsZero() {
+		t.Errorf("expected a bare hash to be read as a record without a size or modification time, got %+v", rec)
+	}
+
+	if rec := outputs["a.min.css"]; rec == nil || rec.Hash != "def" || rec.Size != 3 || rec.ModTime.Year() != 2020 {
+		t.Errorf("expected the full record, got %+v", rec)
+	}
+}
+
+// fakeLessc writes a shell script that stands in for lessc and points pathToLessc at it until the test
+// ends. It answers lessc -v itself and runs body for anything else, with the LESS file as "$last".
+func fakeLessc(t *testing.T, body string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake lessc is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "lessc")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = \"-v\" ]; then echo \"lessc 3.9.0 (Less Compiler) [JavaScript]\"; exit 0; fi\n" +
+		"for a in \"$@\"; do last=\"$a\"; done\n" +
+		body + "\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := pathToLessc
+	pathToLessc = path
+	t.Cleanup(func() { pathToLessc = prev })
+}
+
+// TestCSSJobKilled checks that a lessc that's cancelled or runs past -job-timeout is killed, along with
+// anything it started, and that the job says which it was.
+func TestCSSJobKilled(t *testing.T) {
+	defer func(timeout time.Duration) { jobTimeout = timeout }(jobTimeout)
+
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		cancel    bool
+		cancelled bool
+		timedOut  bool
+	}{
+		{"cancelled", 0, true, true, false},
+		{"timed out", 200 * time.Millisecond, false, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			started, survived := filepath.Join(dir, "started"), filepath.Join(dir, "survived")
+
+			// lessc starts a child that would leave a mark if it outlived it, and hangs
+			fakeLessc(t, fmt.Sprintf(`(sleep 1; touch %q) & touch %q; sleep 30`, survived, started))
+			jobTimeout = test.timeout
+
+			for _, sub := range []string{"less", "css"} {
+				os.Mkdir(filepath.Join(dir, sub), 0755)
+			}
+			ioutil.WriteFile(filepath.Join(dir, "less", "a.less"), []byte(".a{}"), 0644)
+
+			lessDir, _ := os.Open(filepath.Join(dir, "less"))
+			defer lessDir.Close()
+			cssDir, _ := os.Open(filepath.Join(dir, "css"))
+			defer cssDir.Close()
+			fi, err := os.Stat(filepath.Join(dir, "less", "a.less"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			job := newCSSJob(ctx, "a.less", lessDir, cssDir, fi, nil, nil)
+
+			done := make(chan struct{})
+			go func() {
+				job.Run()
+				close(done)
+			}()
+
+			for {
+				if _, err := os.Stat(started); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if test.cancel {
+				cancel()
+			}
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected lessc to be killed")
+			}
+
+			if job.cancelled != test.cancelled || job.timedOut != test.timedOut || job.exitCode == 0 {
+				t.Errorf("expected cancelled = %v and timedOut = %v with a non-zero exit code, got %v, %v and %d", test.cancelled, test.timedOut, job.cancelled, job.timedOut, job.exitCode)
+			}
+
+			time.Sleep(1500 * time.Millisecond)
+			if _, err := os.Stat(survived); err == nil {
+				t.Error("expected lessc's child to be killed too")
+			}
+		})
+	}
+}
+
+// cancelledCtx is cancelled once its flag is set, but never closes Done, so nothing is killed.
+type cancelledCtx struct {
+	context.Context
+	cancelled int32
+}
+
+func (c *cancelledCtx) Err() error {
+	if atomic.LoadInt32(&c.cancelled) != 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// TestCSSJobFailsWhileCancelled checks that a lessc that fails on its own while the run is being
+// cancelled is still counted as a compile error, rather than being dropped as cancelled.
+func TestCSSJobFailsWhileCancelled(t *testing.T) {
+	dir := t.TempDir()
+	started, fail := filepath.Join(dir, "started"), filepath.Join(dir, "fail")
+
+	fakeLessc(t, fmt.Sprintf(`touch %q; while [ ! -e %q ]; do sleep 0.01; done; echo "ParseError: broken" >&2; exit 1`, started, fail))
+
+	for _, sub := range []string{"less", "css"} {
+		os.Mkdir(filepath.Join(dir, sub), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "less", "a.less"), []byte(".a{"), 0644)
+
+	lessDir, _ := os.Open(filepath.Join(dir, "less"))
+	defer lessDir.Close()
+	cssDir, _ := os.Open(filepath.Join(dir, "css"))
+	defer cssDir.Close()
+	fi, err := os.Stat(filepath.Join(dir, "less", "a.less"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &cancelledCtx{Context: context.Background()}
+	job := newCSSJob(ctx, "a.less", lessDir, cssDir, fi, nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		job.Run()
+		close(done)
+	}()
+
+	for {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	atomic.StoreInt32(&ctx.cancelled, 1)
+	ioutil.WriteFile(fail, nil, 0644)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected lessc to exit")
+	}
+
+	if job.cancelled || job.timedOut || job.internal || job.exitCode == 0 {
+		t.Errorf("expected a compile error, got cancelled = %v, timedOut = %v, internal = %v and exit code %d", job.cancelled, job.timedOut, job.internal, job.exitCode)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,12 +10,16 @@ import (
 
 type addFunc func(crawler *directoryCrawler, less_dir, css_dir *os.File, less_file os.FileInfo)
 
+// A failFunc is told about a directory that can't be crawled, with the exit code it should cause.
+type failFunc func(code int, err error)
+
 type directoryCrawler struct {
 	root     *os.File
 	rootCSS  *os.File
 	rootLESS *os.File
 
-	addFunc addFunc
+	addFunc  addFunc
+	failFunc failFunc
 }
 
 func newDirectoryCrawler(path string, addFunc addFunc) (*directoryCrawler, error) {
@@ -63,26 +68,30 @@ func newDirectoryCrawler(path string, addFunc addFunc) (*directoryCrawler, error
 	return c, nil
 }
 
-func (c *directoryCrawler) Parse() error {
-	c.parseDirectory("", c.rootLESS, c.rootCSS)
-	return nil
+// Parse walks the less directory, calling addFunc for each entry point it finds, and failFunc (if it's set)
+// for each directory it can't read or create. It stops early if ctx is cancelled.
+func (c *directoryCrawler) Parse(ctx context.Context) error {
+	c.parseDirectory(ctx, "", c.rootLESS, c.rootCSS)
+	return ctx.Err()
 }
 
-func (c *directoryCrawler) parseDirectory(prefix string, lessDir, cssDir *os.File) {
+func (c *directoryCrawler) parseDirectory(ctx context.Context, prefix string, lessDir, cssDir *os.File) {
 	files, err := lessDir.Readdir(-1)
 	if err != nil {
-		fmt.Printf("Can't scan %s for files", lessDir.Name())
+		c.fail(exitEnvironment, fmt.Errorf("can't scan %s for files: %s", lessDir.Name(), err))
 		return
 	}
 
 	for _, v := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
 		if v.IsDir() {
 			if strings.HasPrefix(v.Name(), "_") {
 				// We're dealing with an underscore-prefixed directory.
-				if isVerbose {
-					dir, _ := filepath.Rel(c.rootLESS.Name(), filepath.Join(lessDir.Name(), v.Name()))
-					fmt.Printf("skip: %s\n", dir+"/*")
-				}
+				dir, _ := filepath.Rel(c.rootLESS.Name(), filepath.Join(lessDir.Name(), v.Name()))
+				log.file(dir).debugf("skip: %s", dir+"/*")
 
 				continue
 			}
@@ -93,24 +102,22 @@ func (c *directoryCrawler) parseDirectory(prefix string, lessDir, cssDir *os.Fil
 				if os.IsNotExist(err) {
 					err = os.Mkdir(cssDir.Name()+string(os.PathSeparator)+v.Name(), 0755)
 					if err != nil {
-						fmt.Println("Can't create css directory")
+						c.fail(exitInternal, fmt.Errorf("can't create css directory: %s", err))
 						return
 					}
 					cssDeeper, _ = os.Open(cssDir.Name() + string(os.PathSeparator) + v.Name())
 				}
 			}
 
-			c.parseDirectory(v.Name()+string(os.PathSeparator), lessDeeper, cssDeeper)
+			c.parseDirectory(ctx, v.Name()+string(os.PathSeparator), lessDeeper, cssDeeper)
 		}
 
 		if !v.IsDir() && lessFilename.MatchString(v.Name()) {
 			if strings.HasPrefix(v.Name(), "_") {
 
 				// We're dealing with an underscore-prefixed file (an include).
-				if isVerbose {
-					filename, _ := filepath.Rel(c.rootLESS.Name(), filepath.Join(lessDir.Name(), v.Name()))
-					fmt.Printf("skip: %s\n", filename)
-				}
+				filename, _ := filepath.Rel(c.rootLESS.Name(), filepath.Join(lessDir.Name(), v.Name()))
+				log.file(filename).debugf("skip: %s", filename)
 
 				continue
 			}
@@ -119,3 +126,13 @@ func (c *directoryCrawler) parseDirectory(prefix string, lessDir, cssDir *os.Fil
 		}
 	}
 }
+
+// fail reports a directory that can't be crawled to failFunc, or just logs it if there isn't one.
+func (c *directoryCrawler) fail(code int, err error) {
+	if c.failFunc == nil {
+		log.errorf("%s", err)
+		return
+	}
+
+	c.failFunc(code, err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestCrawlerFailures checks that directories that can't be crawled are reported with the right exit
+// code, rather than just being logged.
+func TestCrawlerFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, c *directoryCrawler)
+		code  int
+	}{
+		{"less directory can't be scanned", func(t *testing.T, c *directoryCrawler) {
+			c.rootLESS.Close()
+		}, exitEnvironment},
+		{"css directory can't be created", func(t *testing.T, c *directoryCrawler) {
+			if err := os.Symlink(filepath.Join(c.root.Name(), "missing"), filepath.Join(c.rootCSS.Name(), "sub")); err != nil {
+				t.Fatal(err)
+			}
+		}, exitInternal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, "less", "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := newDirectoryCrawler(root, func(*directoryCrawler, *os.File, *os.File, os.FileInfo) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			codes := []int{}
+			c.failFunc = func(code int, err error) {
+				codes = append(codes, code)
+			}
+			test.setup(t, c)
+
+			if err := c.Parse(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(codes) != 1 || codes[0] != test.code {
+				t.Errorf("expected one failure with exit code %d, got %v", test.code, codes)
+			}
+		})
+	}
+}
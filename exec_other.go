@@ -0,0 +1,26 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on platforms without process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd. Anything it started is left running.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+
+	cmd.Process.Kill()
+}
+
+// exitedOnItsOwn returns false, since a process that was killed can't be told from one that exited here.
+func exitedOnItsOwn(state *os.ProcessState) bool {
+	return false
+}
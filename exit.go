@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// less-tree's exit codes, so scripts and CI can tell a broken stylesheet from a broken setup.
+const (
+	exitOK          = 0   // everything was built
+	exitCompile     = 1   // at least one entry point couldn't be analyzed or compiled
+	exitEnvironment = 2   // bad options or config, a missing lessc or directory, or a root that's locked
+	exitInternal    = 3   // less-tree couldn't do something it should have been able to, like write a file
+	exitSignal      = 128 // plus the signal's number when interrupted, like a shell reports it: 130 for SIGINT
+)
+
+// A runStatus counts the failures in a run, to decide the exit code. With -fail-fast, the first failure
+// cancels the rest of the run.
+type runStatus struct {
+	compile     int64
+	environment int64
+	internal    int64
+
+	failFast bool
+	cancel   context.CancelFunc
+	once     sync.Once
+}
+
+func newRunStatus(cancel context.CancelFunc, failFast bool) *runStatus {
+	return &runStatus{cancel: cancel, failFast: failFast}
+}
+
+// fail records a failure, where code says what kind it was.
+func (s *runStatus) fail(code int) {
+	switch code {
+	case exitCompile:
+		atomic.AddInt64(&s.compile, 1)
+	case exitEnvironment:
+		atomic.AddInt64(&s.environment, 1)
+	default:
+		atomic.AddInt64(&s.internal, 1)
+	}
+
+	if s.failFast {
+		s.once.Do(func() {
+			log.warnf("stopping after the first error (-fail-fast)")
+			s.cancel()
+		})
+	}
+}
+
+// exitCode returns the code to exit with: the most serious kind of failure there was, unless the run was
+// interrupted by sig.
+func (s *runStatus) exitCode(sig os.Signal) int {
+	switch {
+	case sig != nil:
+		if num, ok := sig.(syscall.Signal); ok {
+			return exitSignal + int(num)
+		}
+		return exitSignal + int(syscall.SIGINT)
+	case atomic.LoadInt64(&s.internal) > 0:
+		return exitInternal
+	case atomic.LoadInt64(&s.environment) > 0:
+		return exitEnvironment
+	case atomic.LoadInt64(&s.compile) > 0:
+		return exitCompile
+	}
+
+	return exitOK
+}
+
+// A keepGoingFlag is -k, which undoes -fail-fast, so whichever of them comes last wins.
+type keepGoingFlag struct {
+	failFast *bool
+}
+
+func (k keepGoingFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (k keepGoingFlag) String() string {
+	if k.failFast == nil {
+		return "true"
+	}
+	return fmt.Sprint(!*k.failFast)
+}
+
+func (k keepGoingFlag) Set(in string) error {
+	switch in {
+	case "true":
+		*k.failFast = false
+	case "false":
+		*k.failFast = true
+	default:
+		return fmt.Errorf("invalid value %q for -k", in)
+	}
+
+	return nil
+}
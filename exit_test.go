@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []int
+		signal   os.Signal
+		code     int
+	}{
+		{"ok", nil, nil, exitOK},
+		{"compile", []int{exitCompile, exitCompile}, nil, exitCompile},
+		{"environment beats compile", []int{exitCompile, exitEnvironment}, nil, exitEnvironment},
+		{"internal beats everything", []int{exitEnvironment, exitInternal, exitCompile}, nil, exitInternal},
+		{"interrupted", []int{exitInternal}, os.Interrupt, 130},
+		{"terminated", nil, syscall.SIGTERM, 143},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newRunStatus(func() {}, false)
+			for _, code := range test.failures {
+				s.fail(code)
+			}
+
+			if code := s.exitCode(test.signal); code != test.code {
+				t.Errorf("expected %d, got %d", test.code, code)
+			}
+		})
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newRunStatus(cancel, true)
+	s.fail(exitCompile)
+	s.fail(exitCompile)
+
+	if ctx.Err() == nil {
+		t.Fatal("expected the first failure to cancel the run")
+	}
+}
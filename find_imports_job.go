@@ -1,28 +1,38 @@
 package main
 
 import (
-	"fmt"
+	"github.com/jimmysawczuk/worker"
+
+	"context"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 type findImportsJob struct {
 	File *lessFile
 	Name string
 
+	ctx context.Context
+
 	inDir  *os.File
 	outDir *os.File
 	inFile os.FileInfo
 
+	cache *lessTreeCache
+
 	outCh chan *lessFile
 	errCh chan error
 }
 
-func newFindImportsJob(name string, lessDir, cssDir *os.File, inputLessFile os.FileInfo, outCh chan *lessFile, errCh chan error) *findImportsJob {
+func newFindImportsJob(ctx context.Context, name string, lessDir, cssDir *os.File, inputLessFile os.FileInfo, cache *lessTreeCache, outCh chan *lessFile, errCh chan error) *findImportsJob {
 	j := &findImportsJob{
 		Name:   name,
+		ctx:    ctx,
 		inDir:  lessDir,
 		outDir: cssDir,
 		inFile: inputLessFile,
+		cache:  cache,
 		outCh:  outCh,
 		errCh:  errCh,
 	}
@@ -31,11 +41,15 @@ func newFindImportsJob(name string, lessDir, cssDir *os.File, inputLessFile os.F
 }
 
 func (j *findImportsJob) Run() {
-	if isVerbose {
-		fmt.Println("analyze:", j.Name)
+	if j.ctx.Err() != nil {
+		return
 	}
 
-	l, err := newLessFile(j.Name, j.inDir, j.outDir, j.inFile)
+	log.file(j.Name).debugf("analyze: %s", j.Name)
+
+	span := trace.begin("analyze", "analyze", j.Name)
+	l, err := newLessFile(j.Name, j.inDir, j.outDir, j.inFile, j.cache, span)
+	span.end()
 	if err != nil {
 		j.errCh <- err
 		return
@@ -43,3 +57,58 @@ func (j *findImportsJob) Run() {
 
 	j.outCh <- l
 }
+
+// analyzeRoot crawls the root and finds the imports of every entry point in it, using cache to skip files
+// that haven't changed. found is called with each entry point as soon as it's been analyzed, and failed
+// with the error for each one that can't be, one at a time, from a single goroutine. analyzeRoot returns
+// once every entry point has been passed to one or the other.
+func analyzeRoot(ctx context.Context, crawler *directoryCrawler, cache *lessTreeCache, found func(*lessFile), failed func(error)) {
+	analyzeQueue := worker.NewWorker()
+	lessFileCh := make(chan *lessFile, 100)
+	errCh := make(chan error, 100)
+
+	crawler.addFunc = func(crawler *directoryCrawler, less_dir, css_dir *os.File, less_file os.FileInfo) {
+		name, _ := filepath.Rel(crawler.rootLESS.Name(), filepath.Join(less_dir.Name(), less_file.Name()))
+		job := newFindImportsJob(ctx, name, less_dir, css_dir, less_file, cache, lessFileCh, errCh)
+		analyzeQueue.Add(job)
+	}
+
+	// The collector drains both channels until they're closed, which happens only after every job has
+	// finished sending, so nothing buffered is dropped.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		files, errs := lessFileCh, errCh
+		for files != nil || errs != nil {
+			select {
+			case l, ok := <-files:
+				if !ok {
+					files = nil
+					continue
+				}
+				found(l)
+
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				failed(err)
+			}
+		}
+	}()
+
+	span := trace.begin("analyze", "crawl", crawler.root.Name())
+	crawler.Parse(ctx)
+	span.end()
+
+	log.debugf("finished building queue")
+
+	analyzeQueue.RunUntilDone()
+	close(lessFileCh)
+	close(errCh)
+
+	wg.Wait()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAnalyzeRoot runs the analysis on a copy of test/less and checks that every entry point comes out
+// exactly once, and that the second time round they're all reused from the cache. It's most useful with
+// go test -race.
+func TestAnalyzeRoot(t *testing.T) {
+	root := t.TempDir()
+
+	// the copies are backdated, or they'd be too new for their modification times to be trusted
+	old := time.Now().Add(-time.Hour)
+
+	expected := []string{}
+	src := filepath.Join("test", "less")
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(root, "less", rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dest, contents, 0644); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dest, old, old); err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if lessFilename.MatchString(fi.Name()) && !strings.HasPrefix(name, "_") && !strings.Contains(name, "/_") {
+			expected = append(expected, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't copy test/less: %s", err)
+	}
+	sort.Strings(expected)
+
+	cache := newLessTreeCache(filepath.Join(root, ".less-tree-cache"))
+
+	// the second time round, unchanged files are reused from the cache
+	for i := 0; i < 2; i++ {
+		crawler, err := newDirectoryCrawler(root, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := []string{}
+		reused := 0
+		analyzeRoot(context.Background(), crawler, cache, func(l *lessFile) {
+			found = append(found, l.Name)
+			if l.tokens == nil {
+				// it was never read, so its hash and imports came from the cache
+				reused++
+			}
+			cache.Test(l)
+		}, func(err error) {
+			t.Error(err)
+		})
+		sort.Strings(found)
+
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatalf("run %d: expected %v, got %v", i+1, expected, found)
+		}
+
+		if expectedReused := i * len(expected); reused != expectedReused {
+			t.Errorf("run %d: expected %d entry points to be reused from the cache, got %d", i+1, expectedReused, reused)
+		}
+
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		cache = newLessTreeCache(cache.file)
+		if err := cache.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// A buildFingerprint describes the toolchain and options used to build an entry point. If any of it
+// changes, the CSS needs to be rebuilt even if none of the LESS did.
+type buildFingerprint struct {
+	Lessc        string   `json:"lessc"`
+	LesscVersion string   `json:"lesscVersion"`
+	Args         []string `json:"args,omitempty"`
+	Minifier     string   `json:"minifier,omitempty"`
+	Version      string   `json:"version"`
+}
+
+func newBuildFingerprint() *buildFingerprint {
+	f := &buildFingerprint{
+		Lessc:        pathToLessc,
+		LesscVersion: lesscVersion(),
+		Args:         lesscArgs.out,
+		Version:      version,
+	}
+
+	if enableCSSMin {
+		f.Minifier = pathToCSSMin
+	}
+
+	return f
+}
+
+// lesscVersion returns the output of lessc -v, or an empty string if it can't be run.
+func lesscVersion() string {
+	out, err := exec.Command(pathToLessc, "-v").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// diff describes how f differs from a previous fingerprint, or returns an empty string if they match.
+func (f *buildFingerprint) diff(prev *buildFingerprint) string {
+	if prev == nil {
+		return "no build fingerprint in cache"
+	}
+
+	changes := []string{}
+	if f.Lessc != prev.Lessc {
+		changes = append(changes, fmt.Sprintf("lessc path changed: %s → %s", prev.Lessc, f.Lessc))
+	}
+	if f.LesscVersion != prev.LesscVersion {
+		changes = append(changes, fmt.Sprintf("lessc version changed: %s → %s", prev.LesscVersion, f.LesscVersion))
+	}
+	if !equalArgs(f.Args, prev.Args) {
+		changes = append(changes, fmt.Sprintf("lessc args changed: %q → %q", prev.Args, f.Args))
+	}
+	if f.Minifier != prev.Minifier {
+		changes = append(changes, fmt.Sprintf("minifier changed: %q → %q", prev.Minifier, f.Minifier))
+	}
+	if f.Version != prev.Version {
+		changes = append(changes, fmt.Sprintf("less-tree version changed: %s → %s", prev.Version, f.Version))
+	}
+
+	return strings.Join(changes, "; ")
+}
+
+// equalArgs reports whether two argument lists are the same, argument by argument, so ["--x a"] and
+// ["--x", "a"] are different.
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFingerprintDiff(t *testing.T) {
+	base := buildFingerprint{
+		Lessc:        "/usr/bin/lessc",
+		LesscVersion: "lessc 3.9.0 (Less Compiler) [JavaScript]",
+		Args:         []string{"--strict-math=on"},
+		Version:      "1.6.0",
+	}
+
+	tests := []struct {
+		name     string
+		change   func(f *buildFingerprint)
+		expected string
+	}{
+		{"same", func(f *buildFingerprint) {}, ""},
+		{"lessc path", func(f *buildFingerprint) { f.Lessc = "/usr/local/bin/lessc" }, "lessc path changed: /usr/bin/lessc → /usr/local/bin/lessc"},
+		{"lessc version", func(f *buildFingerprint) { f.LesscVersion = "lessc 4.1.0 (Less Compiler) [JavaScript]" }, "lessc version changed: lessc 3.9.0 (Less Compiler) [JavaScript] → lessc 4.1.0 (Less Compiler) [JavaScript]"},
+		{"args", func(f *buildFingerprint) { f.Args = []string{"--strict-math=on", "--ie-compat"} }, `lessc args changed: ["--strict-math=on"] → ["--strict-math=on" "--ie-compat"]`},
+		{"args removed", func(f *buildFingerprint) { f.Args = nil }, `lessc args changed: ["--strict-math=on"] → []`},
+		{"args split", func(f *buildFingerprint) { f.Args = []string{"--strict-math", "on"} }, `lessc args changed: ["--strict-math=on"] → ["--strict-math" "on"]`},
+		{"-min", func(f *buildFingerprint) { f.Minifier = "cssmin" }, `minifier changed: "" → "cssmin"`},
+		{"minifier", func(f *buildFingerprint) { f.Minifier = "cleancss" }, `minifier changed: "" → "cleancss"`},
+		{"less-tree version", func(f *buildFingerprint) { f.Version = "1.7.0" }, "less-tree version changed: 1.6.0 → 1.7.0"},
+		{"several", func(f *buildFingerprint) { f.LesscVersion = "lessc 4.1.0"; f.Version = "1.7.0" }, "lessc version changed: lessc 3.9.0 (Less Compiler) [JavaScript] → lessc 4.1.0; less-tree version changed: 1.6.0 → 1.7.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prev := base
+			cur := base
+			test.change(&cur)
+
+			if actual := cur.diff(&prev); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+
+	// the same when they're joined up, but not the same arguments
+	joined, split := &buildFingerprint{Args: []string{"--include-path a"}}, &buildFingerprint{Args: []string{"--include-path", "a"}}
+	if actual := joined.diff(split); actual != `lessc args changed: ["--include-path" "a"] → ["--include-path a"]` {
+		t.Errorf("expected the arguments to be compared one by one, got %q", actual)
+	}
+
+	if actual := base.diff(nil); actual != "no build fingerprint in cache" {
+		t.Errorf("expected a missing fingerprint to be a change, got %q", actual)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"style.less", "style.less", true},
+		{"*.less", "style.less", true},
+		{"*.less", "admin/style.less", false},
+		{"admin/**", "admin/style.less", true},
+		{"admin/**", "admin/sub/style.less", true},
+		{"admin/**", "public/style.less", false},
+		{"**/style.less", "style.less", true},
+		{"**/style.less", "a/b/style.less", true},
+		{"a/**/b/*.less", "a/x/y/b/c.less", true},
+		{"a/**/b/*.less", "a/b/c.less", true},
+		{"a/**/b/*.less", "a/b/c/d.less", false},
+		{"[", "[", false},
+	}
+
+	for _, test := range tests {
+		if actual := matchGlob(test.pattern, test.name); actual != test.match {
+			t.Errorf("matchGlob(%q, %q): expected %t, got %t", test.pattern, test.name, test.match, actual)
+		}
+	}
+}
+
+func TestForceFlag(t *testing.T) {
+	f := forceFlag{}
+	f.Set("admin/**")
+	f.patterns.Set("./site.less")
+	f.patterns.Set("unused/*")
+
+	for name, expected := range map[string]bool{
+		"admin/users.less":    true,
+		"admin/x/y.less":      true,
+		"site.less":           true,
+		"dir/site.less":       false,
+		"administration.less": false,
+	} {
+		if f.matches(name) != expected {
+			t.Errorf("expected matches(%q) = %v", name, expected)
+		}
+	}
+
+	if unmatched := f.unmatched(); len(unmatched) != 1 || unmatched[0] != "unused/*" {
+		t.Errorf("expected only unused/* to be unmatched, got %v", unmatched)
+	}
+
+	f.Set("true")
+	if !f.matches("anything.less") {
+		t.Error("expected -f on its own to match everything")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An importGraph holds every LESS file under a less root along with the files they import. Unlike
+// newLessFile, building the graph doesn't stop at the first problem; bad imports are recorded on the file
+// that contains them so tools like lint can report all of them at once.
+type importGraph struct {
+	root  string
+	files map[string]*graphFile
+}
+
+type graphFile struct {
+	Path  string
+	Name  string
+	Entry bool
+
+	err     error
+	tokens  []token
+	imports []*graphImport
+}
+
+type graphImport struct {
+	Path    string
+	Options []string
+
+	pos  position
+	file *graphFile
+	err  error
+}
+
+func newImportGraph(root string) (*importGraph, error) {
+	lessDir := filepath.Join(root, "less")
+
+	fi, err := os.Stat(lessDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("directory %s doesn't exist", lessDir)
+		}
+		return nil, fmt.Errorf("can't open %s: %s", lessDir, err)
+	}
+
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", lessDir)
+	}
+
+	g := &importGraph{
+		root:  lessDir,
+		files: map[string]*graphFile{},
+	}
+
+	err = filepath.Walk(lessDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || !lessFilename.MatchString(fi.Name()) {
+			return nil
+		}
+
+		g.load(path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error crawling directory %s: %s", lessDir, err)
+	}
+
+	return g, nil
+}
+
+// load reads, tokenizes and resolves the imports of the file at path, along with everything it imports.
+// Files are only loaded once.
+func (g *importGraph) load(path string) *graphFile {
+	if f, exists := g.files[path]; exists {
+		return f
+	}
+
+	f := &graphFile{
+		Path: path,
+	}
+	g.files[path] = f
+
+	if rel, err := filepath.Rel(g.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		f.Name = filepath.ToSlash(rel)
+		f.Entry = isEntryPoint(f.Name)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		f.err = fmt.Errorf("can't read file %s: %s", path, err)
+		return f
+	}
+
+	f.tokens = tokenize(contents)
+
+	for i := 0; i < len(f.tokens); i++ {
+		tok := f.tokens[i]
+		if tok.kind != tokenAtKeyword || tok.value != importKeyword {
+			continue
+		}
+
+		imp := &graphImport{pos: tok.pos}
+		f.imports = append(f.imports, imp)
+
+		slice, err := sliceUntil(f.tokens, tokenSemicolon, i, 0)
+		if err != nil {
+			imp.err = fmt.Errorf("error parsing import: missing semicolon")
+			continue
+		}
+		i += len(slice) - 1
+
+		imp.Path, imp.Options, err = parseImport(slice)
+		if err != nil {
+			imp.err = fmt.Errorf("error parsing import: %s", err)
+			continue
+		}
+
+		if skipImport(imp.Path) {
+			continue
+		}
+
+		resolved, err := resolveImport(filepath.Dir(path), imp.Path)
+		if err != nil {
+			if !imp.isCSS() {
+				imp.err = err
+			}
+			continue
+		}
+
+		imp.file = g.load(resolved)
+	}
+
+	return f
+}
+
+// entries returns the files that get compiled on their own, sorted by name.
+func (g *importGraph) entries() []*graphFile {
+	entries := []*graphFile{}
+	for _, f := range g.files {
+		if f.Entry {
+			entries = append(entries, f)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+// sorted returns every file in the graph, sorted by path.
+func (g *importGraph) sorted() []*graphFile {
+	files := make([]*graphFile, 0, len(g.files))
+	for _, f := range g.files {
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return files
+}
+
+// closure returns f and every LESS file it imports, directly or indirectly. Files that are pulled in as
+// plain CSS aren't part of the compilation, so they're left out.
+func (g *importGraph) closure(f *graphFile) []*graphFile {
+	seen := map[*graphFile]bool{}
+	files := []*graphFile{}
+
+	var walk func(f *graphFile)
+	walk = func(f *graphFile) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		files = append(files, f)
+
+		for _, imp := range f.imports {
+			if imp.file != nil && !imp.isCSS() && !imp.hasOption("inline") {
+				walk(imp.file)
+			}
+		}
+	}
+	walk(f)
+
+	return files
+}
+
+func (imp *graphImport) hasOption(name string) bool {
+	for _, opt := range imp.Options {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isCSS reports whether lessc will leave the import alone as a plain CSS @import rather than reading it.
+func (imp *graphImport) isCSS() bool {
+	if imp.hasOption("css") {
+		return true
+	}
+
+	return strings.EqualFold(filepath.Ext(imp.Path), ".css") && !imp.hasOption("less") && !imp.hasOption("inline")
+}
+
+// isEntryPoint reports whether a path relative to the less root is compiled on its own, i.e. neither the
+// file nor any of its parent directories start with an underscore.
+func isEntryPoint(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// displayPath returns path relative to the working directory, if possible.
+func displayPath(path string) string {
+	if rel, err := filepath.Rel(workingDirectory, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
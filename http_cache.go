@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxArtifactSize is the largest output the cache server will accept.
+const maxArtifactSize = 64 << 20
+
+// httpCacheTimeout is how long a request to the cache server can take. It's short, since compiling a
+// stylesheet is usually quicker than waiting for a server that isn't answering.
+const httpCacheTimeout = 5 * time.Second
+
+// An httpCache is a buildCache stored on a remote server. The protocol is plain HTTP: each output is a
+// resource at <base url>/<build key>/<artifact name>, read with GET (404 if it isn't there) and stored
+// with PUT. `less-tree serve-cache` is a server that implements it.
+//
+// If the server can't be reached, the cache is switched off for the rest of the run (it acts as if it's
+// empty), so every job doesn't wait for its own timeout.
+type httpCache struct {
+	base   string
+	client *http.Client
+	down   int32
+}
+
+// A cacheServer serves a dirCache over HTTP using the protocol described on httpCache.
+type cacheServer struct {
+	cache    *dirCache
+	readOnly bool
+}
+
+func init() {
+	commands["serve-cache"] = &command{
+		args:        "<dir>",
+		description: "Serve a shared build cache stored in <dir> over HTTP, for use with -cache-url",
+		run:         serveCacheCommand,
+	}
+}
+
+func newHTTPCache(base string) *httpCache {
+	return &httpCache{
+		base: strings.TrimRight(base, "/"),
+		client: &http.Client{
+			Timeout: httpCacheTimeout,
+		},
+	}
+}
+
+func (c *httpCache) url(key, name string) (string, error) {
+	if err := validateArtifact(key, name); err != nil {
+		return "", err
+	}
+
+	return c.base + "/" + key + "/" + name, nil
+}
+
+// disable switches the cache off after the server couldn't be reached, with a warning the first time.
+func (c *httpCache) disable(err error) {
+	if atomic.CompareAndSwapInt32(&c.down, 0, 1) {
+		log.warnf("can't reach the shared cache at %s (%s), so it won't be used for the rest of this run", c.base, err)
+	}
+}
+
+func (c *httpCache) disabled() bool {
+	return atomic.LoadInt32(&c.down) != 0
+}
+
+func (c *httpCache) Get(key, name string) ([]byte, error) {
+	u, err := c.url(key, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.disabled() {
+		return nil, os.ErrNotExist
+	}
+
+	resp, err := c.client.Get(u)
+	if err != nil {
+		c.disable(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	}
+
+	return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+}
+
+func (c *httpCache) Put(key, name string, contents []byte) error {
+	u, err := c.url(key, name)
+	if err != nil {
+		return err
+	}
+
+	if c.disabled() {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.disable(err)
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", u, resp.Status)
+	}
+
+	return nil
+}
+
+func serveCacheCommand(args []string) int {
+	var addr string
+	var readOnly bool
+
+	fs := flag.NewFlagSet("serve-cache", flag.ExitOnError)
+	fs.StringVar(&addr, "addr", "localhost:8080", "Address to listen on")
+	fs.BoolVar(&readOnly, "read-only", false, "Reject uploads")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: less-tree serve-cache [options] <dir>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitEnvironment
+	}
+
+	cache, err := newDirCache(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	fmt.Printf("serving %s on http://%s\n", cache.dir, addr)
+
+	err = http.ListenAndServe(addr, &cacheServer{cache: cache, readOnly: readOnly})
+	fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+
+	return exitEnvironment
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || validateArtifact(parts[0], parts[1]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	key, name := parts[0], parts[1]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		contents, err := s.cache.Get(key, name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(contents)
+
+	case http.MethodPut:
+		if s.readOnly {
+			http.Error(w, "this cache is read-only", http.StatusForbidden)
+			return
+		}
+
+		contents, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxArtifactSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err := s.cache.Put(key, name, contents); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPCache(t *testing.T) {
+	dir, err := newDirCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &cacheServer{cache: dir}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c := newHTTPCache(ts.URL + "/")
+	key := strings.Repeat("ab", 20)
+
+	if _, err := c.Get(key, artifactCSS); !os.IsNotExist(err) {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+
+	if err := c.Put(key, artifactCSS, []byte("h1{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := c.Get(key, artifactCSS)
+	if err != nil || !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected to get back what was stored, got %q, %v", contents, err)
+	}
+
+	if contents, _ := dir.Get(key, artifactCSS); !bytes.Equal(contents, []byte("h1{}")) {
+		t.Fatalf("expected the server to store the output in its directory, got %q", contents)
+	}
+
+	if err := c.Put("../../etc", artifactCSS, nil); err == nil {
+		t.Fatal("expected an invalid key to be rejected")
+	}
+
+	resp, err := http.Get(ts.URL + "/" + key + "/..%2f..%2fpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected an invalid artifact name to 404, got %s", resp.Status)
+	}
+
+	server.readOnly = true
+	if err := c.Put(key, artifactMinCSS, []byte("h1{}")); err == nil {
+		t.Fatal("expected a read-only server to reject uploads")
+	}
+
+	if err := (readOnlyCache{c}).Put(key, artifactMinCSS, []byte("h1{}")); err != nil {
+		t.Fatalf("expected a read-only cache to silently skip uploads, got %v", err)
+	}
+}
+
+func TestHTTPCacheUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	c := newHTTPCache(ts.URL)
+	key := strings.Repeat("ab", 20)
+
+	if _, err := c.Get(key, artifactCSS); err == nil || os.IsNotExist(err) {
+		t.Fatalf("expected a connection error the first time, got %v", err)
+	}
+
+	if !c.disabled() {
+		t.Fatal("expected the cache to be switched off after the server couldn't be reached")
+	}
+
+	if _, err := c.Get(key, artifactCSS); !os.IsNotExist(err) {
+		t.Fatalf("expected a switched off cache to act as if it's empty, got %v", err)
+	}
+
+	if err := c.Put(key, artifactCSS, []byte("h1{}")); err != nil {
+		t.Fatalf("expected a switched off cache to skip uploads, got %v", err)
+	}
+}
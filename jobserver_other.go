@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// A jobserver does nothing on platforms where make's jobserver isn't a pipe.
+type jobserver struct{}
+
+// openJobserver ignores make's jobserver, which is only supported on unix.
+func openJobserver(auth string) (*jobserver, error) {
+	return nil, nil
+}
+
+func (j *jobserver) acquire() {}
+
+func (j *jobserver) release() {}
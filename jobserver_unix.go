@@ -0,0 +1,106 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A jobserver is GNU make's jobserver: a pipe holding a byte for every job that can be run on top of the
+// ones already running. less-tree is already running as one of make's jobs, so its first job is free;
+// every job that runs alongside it takes a byte from the pipe first, and puts it back when it's done.
+type jobserver struct {
+	r, w *os.File
+
+	mu     sync.Mutex
+	free   bool
+	tokens []byte
+}
+
+// openJobserver opens the jobserver described by auth, which is either the read and write ends of a pipe
+// make passed down, like 3,4, or a named pipe, like fifo:/tmp/GMfifo123.
+func openJobserver(auth string) (*jobserver, error) {
+	if strings.HasPrefix(auth, "fifo:") {
+		f, err := os.OpenFile(strings.TrimPrefix(auth, "fifo:"), os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return &jobserver{r: f, w: f, free: true}, nil
+	}
+
+	fds := strings.Split(auth, ",")
+	if len(fds) != 2 {
+		return nil, fmt.Errorf("unknown jobserver %q", auth)
+	}
+
+	rfd, err := strconv.Atoi(fds[0])
+	if err != nil {
+		return nil, fmt.Errorf("unknown jobserver %q", auth)
+	}
+
+	wfd, err := strconv.Atoi(fds[1])
+	if err != nil {
+		return nil, fmt.Errorf("unknown jobserver %q", auth)
+	}
+
+	if rfd < 0 || wfd < 0 {
+		return nil, nil
+	}
+
+	r, w := os.NewFile(uintptr(rfd), "jobserver-r"), os.NewFile(uintptr(wfd), "jobserver-w")
+	if _, err := r.Stat(); err != nil {
+		return nil, fmt.Errorf("make didn't pass it on; put a + before the recipe that runs less-tree")
+	}
+	if _, err := w.Stat(); err != nil {
+		return nil, fmt.Errorf("make didn't pass it on; put a + before the recipe that runs less-tree")
+	}
+
+	// lessc has no use for them
+	syscall.CloseOnExec(rfd)
+	syscall.CloseOnExec(wfd)
+
+	return &jobserver{r: r, w: w, free: true}, nil
+}
+
+func (j *jobserver) acquire() {
+	j.mu.Lock()
+	if j.free {
+		j.free = false
+		j.mu.Unlock()
+		return
+	}
+	j.mu.Unlock()
+
+	// If the jobserver's gone, run the job anyway rather than never running it.
+	token := make([]byte, 1)
+	if _, err := io.ReadFull(j.r, token); err != nil {
+		return
+	}
+
+	j.mu.Lock()
+	j.tokens = append(j.tokens, token[0])
+	j.mu.Unlock()
+}
+
+func (j *jobserver) release() {
+	j.mu.Lock()
+	if len(j.tokens) == 0 {
+		j.free = true
+		j.mu.Unlock()
+		return
+	}
+
+	token := j.tokens[len(j.tokens)-1]
+	j.tokens = j.tokens[:len(j.tokens)-1]
+	j.mu.Unlock()
+
+	j.w.Write([]byte{token})
+}
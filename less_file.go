@@ -3,11 +3,14 @@ package main
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type lessFile struct {
@@ -15,12 +18,26 @@ type lessFile struct {
 	Dir    *os.File    `json:"-"`
 	CSSDir *os.File    `json:"-"`
 	File   os.FileInfo `json:"-"`
-	Path   string      `json:"-"`
+	Path   string      `json:"path,omitempty"`
 
 	Imports []*lessImport `json:"imports,omitempty"`
 	Hash    string        `json:"hash"`
+	Size    int64         `json:"size,omitempty"`
+	ModTime time.Time     `json:"modTime"`
+	Vars    modifyVars    `json:"vars,omitempty"`
 
-	tokens []string
+	Fingerprint *buildFingerprint `json:"fingerprint,omitempty"`
+
+	// Outputs are the CSS files last written for this entry point, by file name, so changes made to
+	// them by anything else can be spotted.
+	Outputs map[string]*outputRecord `json:"outputs,omitempty"`
+
+	// Duration is how long the entry point took to compile last time, for scheduling.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	tokens []token
+	cache  *lessTreeCache
+	span   *traceSpan
 }
 
 type lessImport struct {
@@ -28,7 +45,18 @@ type lessImport struct {
 	File    *lessFile `json:"file"`
 }
 
-func newLessFile(name string, lessDir, cssDir *os.File, inputLessFile os.FileInfo) (*lessFile, error) {
+// An outputRecord is the sha1 hash of a CSS file less-tree wrote, along with its size and modification
+// time, so it only has to be hashed again if one of them changes.
+type outputRecord struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// newLessFile hashes the given file and finds its imports. If cache has an entry for the file with the
+// same size and modification time, its hash and imports are reused rather than reading the file again.
+// Each step is traced as a child of span, if it's being traced.
+func newLessFile(name string, lessDir, cssDir *os.File, inputLessFile os.FileInfo, cache *lessTreeCache, span *traceSpan) (*lessFile, error) {
 
 	l := new(lessFile)
 	l.Name = name
@@ -36,20 +64,44 @@ func newLessFile(name string, lessDir, cssDir *os.File, inputLessFile os.FileInf
 	l.File = inputLessFile
 	l.CSSDir = cssDir
 	l.Path = filepath.Join(l.Dir.Name(), l.File.Name())
+	l.Size = inputLessFile.Size()
+	l.ModTime = inputLessFile.ModTime()
+	l.cache = cache
+	l.span = span
+
+	if cached := cache.lookup(l.Path, inputLessFile); cached != nil {
+		step := span.child("resolve imports", name)
+		err := l.reuse(cached)
+		step.end()
+		if err != nil {
+			return nil, fmt.Errorf("import parse error: %s", err)
+		}
+
+		l.Dir.Close()
+
+		return l, nil
+	}
 
+	step := span.child("hash", name)
 	lessContent, err := ioutil.ReadFile(l.Path)
 	if err != nil {
+		step.end()
 		return nil, fmt.Errorf("can't read file %s: %s\n", l.Path, err)
 	}
 
 	hash := sha1.Sum(lessContent)
 	str := hex.EncodeToString(hash[:])
 	l.Hash = str
+	step.end()
 
+	step = span.child("tokenize", name)
 	l.tokens = tokenize(lessContent)
 	l.Imports = make([]*lessImport, 0)
+	step.end()
 
+	step = span.child("resolve imports", name)
 	err = l.findImports()
+	step.end()
 	if err != nil {
 		return nil, fmt.Errorf("import parse error: %s", err)
 	}
@@ -59,6 +111,54 @@ func newLessFile(name string, lessDir, cssDir *os.File, inputLessFile os.FileInf
 	return l, nil
 }
 
+// reuse copies the hash and imports from an unchanged file's cache entry. The imports themselves might
+// have changed, so each of them is checked again.
+func (l *lessFile) reuse(cached *lessFile) error {
+	l.Hash = cached.Hash
+	l.Imports = make([]*lessImport, 0, len(cached.Imports))
+
+	for _, v := range cached.Imports {
+		if v.File == nil || v.File.Path == "" {
+			return fmt.Errorf("cache entry for %s is missing import paths", l.Name)
+		}
+
+		dir, err := os.Open(filepath.Dir(v.File.Path))
+		if err != nil {
+			return fmt.Errorf("import path %s is not valid: %s", v.File.Name, err)
+		}
+
+		fi, err := os.Stat(v.File.Path)
+		if err != nil {
+			dir.Close()
+			return fmt.Errorf("can't stat path %s: %s", v.File.Name, err)
+		}
+
+		file, err := newLessFile(v.File.Name, dir, nil, fi, l.cache, l.span)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+
+		l.Imports = append(l.Imports, &lessImport{
+			Options: v.Options,
+			File:    file,
+		})
+	}
+
+	return nil
+}
+
+// UnmarshalJSON also reads the bare hashes outputs were recorded as before schema 2. Without a size or
+// modification time, they're hashed again the next time they're checked.
+func (o *outputRecord) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &o.Hash); err == nil {
+		return nil
+	}
+
+	type record outputRecord
+	return json.Unmarshal(b, (*record)(o))
+}
+
 func (l *lessFile) String() string {
 	return l.prefixString(0) + "\n"
 }
@@ -82,18 +182,18 @@ func (l *lessFile) prefixString(width int) string {
 func (l *lessFile) findImports() error {
 	i := 0
 	for i < len(l.tokens) {
-		token := l.tokens[i]
+		tok := l.tokens[i]
 
-		switch token {
-		case importToken:
-			slice, err := sliceUntil(l.tokens, ";", i, 0)
+		switch {
+		case tok.kind == tokenAtKeyword && tok.value == importKeyword:
+			slice, err := sliceUntil(l.tokens, tokenSemicolon, i, 0)
 			if err != nil {
-				return fmt.Errorf("error parsing import: missing semicolon")
+				return fmt.Errorf("%s:%s: error parsing import: missing semicolon", l.Path, tok.pos)
 			}
 
 			imp, err := l.NewLESSImport(slice)
 			if err != nil {
-				return fmt.Errorf("error parsing import: %s", err)
+				return fmt.Errorf("%s:%s: error parsing import: %s", l.Path, tok.pos, err)
 			}
 
 			if imp != nil {
@@ -110,71 +210,117 @@ func (l *lessFile) findImports() error {
 	return nil
 }
 
-func (l *lessFile) NewLESSImport(in []string) (imp *lessImport, err error) {
-	i := 1
-	if len(in) <= 1 {
-		return nil, fmt.Errorf("not enough parameters")
+func (l *lessFile) NewLESSImport(in []token) (imp *lessImport, err error) {
+	path, options, err := parseImport(in)
+	if err != nil {
+		return nil, err
 	}
 
 	imp = new(lessImport)
-	imp.Options = []string{}
+	imp.Options = options
 
-	if in[1] == lParenToken {
-		opts := []string{}
-		opts, err = sliceUntilMatching(in, lParenToken, rParenToken, 1, 0)
-		if err != nil {
-			return nil, fmt.Errorf("missing a )")
-		}
+	if skipImport(path) {
+		return nil, nil
+	}
 
-		imp.Options = opts[1 : len(opts)-1]
+	filePath, err := resolveImport(l.Dir.Name(), path)
+	if err != nil {
+		return nil, err
+	}
 
-		i += len(opts)
+	dir, err := os.Open(filepath.Dir(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("import path %s is not valid: %s", path, err)
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't stat path %s: %s", path, err)
 	}
 
-	// remove quotes
-	path := in[i][1 : len(in[i])-1]
+	imp.File, err = newLessFile(path, dir, nil, fi, l.cache, l.span)
 
-	if u, err := url.Parse(path); err == nil {
-		if u.IsAbs() {
-			// this is an absolute url
-			return nil, nil
-		}
+	dir.Close()
+
+	return imp, err
+}
+
+// skipImport reports whether an import path is something we can't follow: an absolute url, or a path
+// that depends on a variable, which we can't resolve without evaluating the file.
+func skipImport(path string) bool {
+	if strings.Contains(path, "@{") {
+		return true
 	}
 
-	dirName, fileName := filepath.Split(path)
+	if u, err := url.Parse(path); err == nil && u.IsAbs() {
+		return true
+	}
+
+	return false
+}
+
+// resolveImport returns the path of the file an import refers to, given the directory of the file that
+// imports it.
+func resolveImport(dir, path string) (string, error) {
+	filePath := path
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(dir, filePath)
+	}
 
-	var dir, file *os.File
-	if dirName == "" {
-		dir = l.Dir
-	} else if !filepath.IsAbs(dirName) {
-		dir, err = os.Open(filepath.Join(l.Dir.Name(), dirName))
-	} else {
-		dir, err = os.Open(filepath.Join(dirName))
+	if filepath.Ext(filePath) == "" {
+		filePath = filePath + ".less"
 	}
 
+	fi, err := os.Stat(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("import path %s is not valid: %s", path, err)
+		return "", fmt.Errorf("import path %s is not valid: %s", path, err)
 	}
 
-	ext := filepath.Ext(fileName)
-	if ext == "" {
-		fileName = fileName + ".less"
+	if fi.IsDir() {
+		return "", fmt.Errorf("import path %s is a directory", path)
 	}
 
-	file, err = os.Open(filepath.Join(dir.Name(), fileName))
-	if err != nil {
-		return nil, fmt.Errorf("import path %s is not valid: %s", path, err)
+	return filePath, nil
+}
+
+// parseImport reads the options and path out of an @import statement, from the @import keyword up to
+// and including the semicolon.
+func parseImport(in []token) (path string, options []string, err error) {
+	i := 1
+	if len(in) <= 1 {
+		return "", nil, fmt.Errorf("not enough parameters")
 	}
 
-	fi, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("can't stat path %s: %s", path, err)
+	options = []string{}
+
+	if in[1].kind == tokenLParen {
+		opts, err := sliceUntilMatching(in, tokenLParen, tokenRParen, 1, 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("missing a )")
+		}
+
+		for _, opt := range opts[1 : len(opts)-1] {
+			options = append(options, opt.value)
+		}
+
+		i += len(opts)
 	}
 
-	imp.File, err = newLessFile(path, dir, nil, fi)
+	if i >= len(in) {
+		return "", nil, fmt.Errorf("missing import path")
+	}
 
-	dir.Close()
-	file.Close()
+	switch in[i].kind {
+	case tokenString, tokenURL:
+		path = unquote(in[i])
 
-	return imp, err
+	default:
+		return "", nil, fmt.Errorf("%s: expected a path, found %s", in[i].pos, in[i].kind)
+	}
+
+	if path == "" {
+		return "", nil, fmt.Errorf("%s: empty import path", in[i].pos)
+	}
+
+	return path, options, nil
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseImportGolden(t *testing.T) {
+	for name, contents := range lessFixtures(t) {
+		buf := &bytes.Buffer{}
+		tokens := tokenize(contents)
+
+		for i, tok := range tokens {
+			if tok.kind != tokenAtKeyword || tok.value != importKeyword {
+				continue
+			}
+
+			slice, err := sliceUntil(tokens, tokenSemicolon, i, 0)
+			if err != nil {
+				fmt.Fprintf(buf, "%s: %s\n", tok.pos, err)
+				continue
+			}
+
+			path, options, err := parseImport(slice)
+			if err != nil {
+				fmt.Fprintf(buf, "%s: %s\n", tok.pos, err)
+				continue
+			}
+
+			fmt.Fprintf(buf, "%s: %q %q\n", tok.pos, path, options)
+		}
+
+		if buf.Len() > 0 {
+			checkGolden(t, "imports/"+name, buf.Bytes())
+		}
+	}
+}
+
+func FuzzParseImport(f *testing.F) {
+	f.Add([]byte(`(reference) "_include.less"`))
+	f.Add([]byte(`(inline) 'test-css.css'`))
+	f.Add([]byte(`url("https://example.com/a.css")`))
+	f.Add([]byte(`(less`))
+	f.Add([]byte(`()`))
+	f.Add([]byte(``))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		src := append([]byte("@import "), in...)
+		tokens := tokenize(src)
+
+		// the statement findImports would pass on, which has to be the start of the tokens up to and
+		// including the first semicolon
+		slice, err := sliceUntil(tokens, tokenSemicolon, 0, 0)
+		if err != nil {
+			slice = tokens
+		} else if len(slice) == 0 || len(slice) > len(tokens) || &slice[0] != &tokens[0] || slice[len(slice)-1].kind != tokenSemicolon {
+			t.Fatalf("sliceUntil returned %d tokens out of %d, not ending at a semicolon, for %q", len(slice), len(tokens), in)
+		}
+
+		path, options, err := parseImport(slice)
+		if err != nil {
+			return
+		}
+
+		if path == "" {
+			t.Fatalf("parsed an empty path out of %q", in)
+		}
+		if !bytes.Contains(src, []byte(path)) {
+			t.Fatalf("parsed a path (%q) that isn't in the input %q", path, in)
+		}
+		if len(options) >= len(slice) {
+			t.Fatalf("parsed %d options out of %d tokens from %q", len(options), len(slice), in)
+		}
+	})
+}
+
+// TestNewLessFileCache checks when newLessFile trusts a cache entry's size and modification time instead
+// of hashing the file again. Entries that are reused are marked with a hash no real file would have.
+func TestNewLessFileCache(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write := func(name, contents string, modTime time.Time) {
+		t.Helper()
+
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open := func(name string, cache *lessTreeCache) (*lessFile, error) {
+		t.Helper()
+
+		d, err := os.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return newLessFile(name, d, nil, fi, cache, nil)
+	}
+
+	// cached returns a cache of a.less as it is now, with every hash replaced by "cached".
+	cached := func() *lessTreeCache {
+		t.Helper()
+
+		c := openTestCache(t, "")
+		l, err := open("a.less", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Files[l.Name] = l
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		c = newLessTreeCache(c.file)
+		if err := c.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range c.byPath {
+			f.Hash = "cached"
+		}
+
+		return c
+	}
+
+	reset := func() {
+		write("a.less", `@import "_b"; .a { color: @color; }`, old)
+		write("_b.less", `@color: red;`, old)
+	}
+
+	tests := []struct {
+		name         string
+		change       func(c *lessTreeCache)
+		reused       bool
+		importReused bool
+	}{
+		{"unchanged", func(c *lessTreeCache) {}, true, true},
+		{"size changed", func(c *lessTreeCache) { write("a.less", `@import "_b"; .a { color: @color; width: 1px; }`, old) }, false, true},
+		{"mtime changed", func(c *lessTreeCache) { write("a.less", `@import "_b"; .a { color: @color; }`, old.Add(time.Second)) }, false, true},
+		{"import changed", func(c *lessTreeCache) { write("_b.less", `@color: blue;`, old) }, true, false},
+		{"racy", func(c *lessTreeCache) { c.Generated = old.Add(racyWindow / 2) }, false, false},
+		{"paranoid", func(c *lessTreeCache) { paranoid = true }, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() { paranoid = false }()
+
+			reset()
+			c := cached()
+			test.change(c)
+
+			l, err := open("a.less", c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reused := l.Hash == "cached"; reused != test.reused {
+				t.Errorf("expected reused = %v, got hash %s", test.reused, l.Hash)
+			}
+
+			if len(l.Imports) != 1 {
+				t.Fatalf("expected _b.less to be imported, got %d imports", len(l.Imports))
+			}
+			if reused := l.Imports[0].File.Hash == "cached"; reused != test.importReused {
+				t.Errorf("expected the import's reused = %v, got hash %s", test.importReused, l.Imports[0].File.Hash)
+			}
+		})
+	}
+
+	t.Run("deleted import", func(t *testing.T) {
+		reset()
+		c := cached()
+		if err := os.Remove(filepath.Join(dir, "_b.less")); err != nil {
+			t.Fatal(err)
+		}
+
+		if l, err := open("a.less", c); err == nil {
+			t.Fatalf("expected an error for the deleted import, got %+v", l.Imports)
+		}
+	})
+}
@@ -2,43 +2,183 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"time"
 )
 
+// cacheSchema is the version of the cache file format. Bump it (and add a migration) whenever the format
+// changes in a way older versions can't read; caches from before it was introduced are schema 0.
+const cacheSchema = 2
+
+// cacheMigrations upgrade a cache loaded from the given schema version to the next one.
+var cacheMigrations = map[int]func(c *lessTreeCache) error{
+	0: func(c *lessTreeCache) error {
+		// Schema 0 files have the same shape, just without fingerprints, paths or modification
+		// times, so every entry gets rebuilt once and filled in.
+		return nil
+	},
+	1: func(c *lessTreeCache) error {
+		// Schema 1 outputs are just hashes, which outputRecord reads on its own, so they're checked
+		// by hash once more and then recorded with their size and modification time.
+		return nil
+	},
+}
+
 type lessTreeCache struct {
+	Schema    int                  `json:"schema"`
 	Version   string               `json:"version"`
 	Generated time.Time            `json:"generated"`
 	Files     map[string]*lessFile `json:"files"`
 
-	rootDir *os.File
+	file   string
+	byPath map[string]*lessFile
+}
+
+// A cacheLoadError means the cache file exists but can't be used, and has been discarded.
+type cacheLoadError struct {
+	path   string
+	reason string
 }
 
-func newLessTreeCache(dir *os.File) *lessTreeCache {
+func (e cacheLoadError) Error() string {
+	return fmt.Sprintf("discarding %s: %s", e.path, e.reason)
+}
+
+// racyWindow is how long before the cache was written a file has to have been modified for its
+// modification time to be trusted. Anything more recent could have changed again within the timestamp
+// granularity of the filesystem.
+const racyWindow = 2 * time.Second
+
+// newLessTreeCache returns an empty cache stored in the file at path (see stateFor).
+func newLessTreeCache(path string) *lessTreeCache {
 	cm := &lessTreeCache{
+		Schema:    cacheSchema,
 		Version:   version,
 		Generated: time.Now(),
 		Files:     make(map[string]*lessFile, 0),
-		rootDir:   dir,
+		file:      path,
 	}
 
 	return cm
 }
 
+// Load reads the cache file. If it's missing, the error satisfies os.IsNotExist; if it's corrupt or from
+// a version of less-tree we don't understand, it's a cacheLoadError. Either way the cache is left empty.
 func (c *lessTreeCache) Load() error {
-	contents, err := ioutil.ReadFile(filepath.Join(c.rootDir.Name(), ".less-tree-cache"))
+	path := c.file
+	contents, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(contents, c)
+	header := struct {
+		Schema  int    `json:"schema"`
+		Version string `json:"version"`
+	}{}
+	if err := json.Unmarshal(contents, &header); err != nil {
+		return cacheLoadError{path, fmt.Sprintf("it's corrupt (%s)", err)}
+	}
+
+	if header.Schema > cacheSchema || header.Schema < 0 {
+		return cacheLoadError{path, fmt.Sprintf("it was written by an incompatible version of less-tree (v%s, schema %d)", header.Version, header.Schema)}
+	}
+
+	// Decode into a separate cache so a file that's only partly valid doesn't leave c half-filled.
+	loaded := &lessTreeCache{}
+	if err := json.Unmarshal(contents, loaded); err != nil {
+		return cacheLoadError{path, fmt.Sprintf("it's corrupt (%s)", err)}
+	}
+
+	for loaded.Schema < cacheSchema {
+		if err := cacheMigrations[loaded.Schema](loaded); err != nil {
+			return cacheLoadError{path, fmt.Sprintf("can't migrate it from schema %d: %s", loaded.Schema, err)}
+		}
+		loaded.Schema++
+	}
+
+	if loaded.Files == nil {
+		return cacheLoadError{path, "it's corrupt (no files)"}
+	}
+
+	for name, f := range loaded.Files {
+		if err := validateCacheEntry(f); err != nil {
+			return cacheLoadError{path, fmt.Sprintf("it's corrupt (entry %s: %s)", name, err)}
+		}
+	}
 
-	return err
+	c.Files = loaded.Files
+	c.Version = loaded.Version
+	c.Generated = loaded.Generated
+
+	c.byPath = map[string]*lessFile{}
+	for _, f := range c.Files {
+		c.index(f)
+	}
+
+	return nil
 }
 
+func validateCacheEntry(f *lessFile) error {
+	if f == nil {
+		return fmt.Errorf("empty entry")
+	}
+
+	if f.Name == "" || f.Hash == "" {
+		return fmt.Errorf("missing name or hash")
+	}
+
+	for _, imp := range f.Imports {
+		if imp == nil || imp.File == nil {
+			return fmt.Errorf("empty import")
+		}
+
+		if err := validateCacheEntry(imp.File); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *lessTreeCache) index(f *lessFile) {
+	if f == nil {
+		return
+	}
+
+	if f.Path != "" {
+		c.byPath[f.Path] = f
+	}
+
+	for _, imp := range f.Imports {
+		c.index(imp.File)
+	}
+}
+
+// lookup returns the cached entry for the file at path if its size and modification time still match,
+// or nil if it needs to be hashed again.
+func (c *lessTreeCache) lookup(path string, fi os.FileInfo) *lessFile {
+	if c == nil || paranoid {
+		return nil
+	}
+
+	cached, exists := c.byPath[path]
+	if !exists || cached.Size != fi.Size() || !cached.ModTime.Equal(fi.ModTime()) {
+		return nil
+	}
+
+	if !cached.ModTime.Before(c.Generated.Add(-racyWindow)) {
+		return nil
+	}
+
+	return cached
+}
+
+// Save writes the cache file. It's written to a temporary file and renamed into place, so an
+// interrupted save leaves the previous cache intact.
 func (c *lessTreeCache) Save() error {
+	c.Schema = cacheSchema
 	c.Version = version
 	c.Generated = time.Now()
 
@@ -47,31 +187,48 @@ func (c *lessTreeCache) Save() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(filepath.Join(c.rootDir.Name(), ".less-tree-cache"), contents, 0644)
-
-	return err
+	return writeFileAtomic(c.file, contents, 0644)
 }
 
-func (c *lessTreeCache) Test(current *lessFile) bool {
+// Test reports whether the cached build of current is still fresh, and if it isn't, why. Either way,
+// current replaces whatever was cached, keeping its record of the outputs.
+func (c *lessTreeCache) Test(current *lessFile) (bool, string) {
+	fresh, reason := c.Check(current)
+
+	if cached, exists := c.Files[current.Name]; exists {
+		current.Outputs = cached.Outputs
+		current.Duration = cached.Duration
+	}
+	c.Files[current.Name] = current
+
+	return fresh, reason
+}
 
+// Check is like Test, but leaves the cache as it is.
+func (c *lessTreeCache) Check(current *lessFile) (bool, string) {
 	cached, exists := c.Files[current.Name]
 	if !exists {
-		c.Files[current.Name] = current
-		return false
+		return false, "not in cache"
 	}
 
 	if cached.Hash != current.Hash {
-		c.Files[current.Name] = current
-		return false
+		return false, "file changed"
 	}
 
-	res := c.testImports(current, cached)
+	if !cached.Vars.equal(current.Vars) {
+		return false, "variables changed"
+	}
 
-	c.Files[current.Name] = current
-	return res
+	if current.Fingerprint != nil {
+		if diff := current.Fingerprint.diff(cached.Fingerprint); diff != "" {
+			return false, diff
+		}
+	}
+
+	return c.testImports(current, cached)
 }
 
-func (c *lessTreeCache) testImports(current, cached *lessFile) bool {
+func (c *lessTreeCache) testImports(current, cached *lessFile) (bool, string) {
 	var curFile, cachedFile *lessFile
 
 	for _, a := range current.Imports {
@@ -85,15 +242,14 @@ func (c *lessTreeCache) testImports(current, cached *lessFile) bool {
 			}
 		}
 
-		if match {
-			res := c.testImports(curFile, cachedFile)
-			if !res {
-				return false
-			}
-		} else {
-			return false
+		if !match {
+			return false, fmt.Sprintf("import %s changed", a.File.Name)
+		}
+
+		if fresh, reason := c.testImports(curFile, cachedFile); !fresh {
+			return false, reason
 		}
 	}
 
-	return true
+	return true, ""
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestCache(t *testing.T, contents string) *lessTreeCache {
+	t.Helper()
+
+	dir := t.TempDir()
+	if contents != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, ".less-tree-cache"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newLessTreeCache(filepath.Join(dir, ".less-tree-cache"))
+}
+
+func TestCacheLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		files    int
+		corrupt  bool
+	}{
+		{"truncated", `{"schema": 1, "files": {"a.less": {"name": "a.le`, 0, true},
+		{"not json", "\x00\x01garbage", 0, true},
+		{"newer schema", `{"schema": 99, "version": "9.0.0", "files": {}}`, 0, true},
+		{"null entry", `{"schema": 1, "files": {"a.less": null}}`, 0, true},
+		{"missing hash", `{"schema": 1, "files": {"a.less": {"name": "a.less"}}}`, 0, true},
+		{"empty import", `{"schema": 1, "files": {"a.less": {"name": "a.less", "hash": "x", "imports": [null]}}}`, 0, true},
+		{"legacy", `{"version": "1.5.0", "files": {"a.less": {"name": "a.less", "hash": "x"}}}`, 1, false},
+		{"current", `{"schema": 1, "files": {"a.less": {"name": "a.less", "hash": "x"}, "b.less": {"name": "b.less", "hash": "y"}}}`, 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := openTestCache(t, test.contents)
+			err := c.Load()
+
+			if _, isLoadErr := err.(cacheLoadError); isLoadErr != test.corrupt {
+				t.Fatalf("expected corrupt = %v, got %v", test.corrupt, err)
+			}
+			if !test.corrupt && err != nil {
+				t.Fatal(err)
+			}
+
+			if len(c.Files) != test.files {
+				t.Errorf("expected %d files, got %d", test.files, len(c.Files))
+			}
+		})
+	}
+}
+
+func TestCacheLoadMissing(t *testing.T) {
+	c := openTestCache(t, "")
+	if err := c.Load(); !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestCacheSaveRoundTrip(t *testing.T) {
+	c := openTestCache(t, "")
+	c.Files["a.less"] = &lessFile{Name: "a.less", Hash: "x", Vars: modifyVars{"color": "red"}}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := ioutil.ReadDir(filepath.Dir(c.file))
+	if len(entries) != 1 {
+		t.Fatalf("expected only the cache file to be left behind, got %d files", len(entries))
+	}
+
+	loaded := newLessTreeCache(c.file)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if f := loaded.Files["a.less"]; f == nil || f.Hash != "x" || f.Vars["color"] != "red" {
+		t.Fatalf("expected the saved entry back, got %+v", f)
+	}
+}
+
+func TestCacheCheck(t *testing.T) {
+	fingerprint := func(change func(f *buildFingerprint)) *buildFingerprint {
+		f := &buildFingerprint{Lessc: "lessc", LesscVersion: "lessc 3.9.0", Args: []string{"--ie-compat"}, Version: "1.6.0"}
+		change(f)
+		return f
+	}
+	same := func(f *buildFingerprint) {}
+
+	include := &lessFile{Name: "_include.less", Hash: "i"}
+	cached := &lessFile{
+		Name:        "a.less",
+		Hash:        "a",
+		Vars:        modifyVars{"color": "red"},
+		Fingerprint: fingerprint(same),
+		Imports:     []*lessImport{{File: include}},
+	}
+
+	tests := []struct {
+		name     string
+		current  lessFile
+		expected string
+	}{
+		{"unchanged", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(same)}, ""},
+		{"not in cache", lessFile{Name: "b.less"}, "not in cache"},
+		{"file changed", lessFile{Hash: "b"}, "file changed"},
+		{"variables changed", lessFile{Hash: "a", Vars: modifyVars{"color": "blue"}}, "variables changed"},
+		{"lessc version", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(func(f *buildFingerprint) { f.LesscVersion = "lessc 4.1.0" })}, "lessc version changed: lessc 3.9.0 → lessc 4.1.0"},
+		{"args", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(func(f *buildFingerprint) { f.Args = nil })}, `lessc args changed: ["--ie-compat"] → []`},
+		{"-min", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(func(f *buildFingerprint) { f.Minifier = "cssmin" })}, `minifier changed: "" → "cssmin"`},
+		{"less-tree version", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(func(f *buildFingerprint) { f.Version = "1.7.0" })}, "less-tree version changed: 1.6.0 → 1.7.0"},
+		{"import changed", lessFile{Hash: "a", Vars: modifyVars{"color": "red"}, Fingerprint: fingerprint(same), Imports: []*lessImport{{File: &lessFile{Name: "_include.less", Hash: "j"}}}}, "import _include.less changed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := openTestCache(t, "")
+			c.Files["a.less"] = cached
+
+			current := test.current
+			if current.Name == "" {
+				current.Name = "a.less"
+			}
+			if current.Imports == nil {
+				current.Imports = []*lessImport{{File: include}}
+			}
+
+			fresh, reason := c.Check(&current)
+			if fresh != (test.expected == "") || reason != test.expected {
+				t.Errorf("expected %q, got %v, %q", test.expected, fresh, reason)
+			}
+		})
+	}
+}
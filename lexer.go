@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenError tokenKind = iota
+	tokenWord
+	tokenAtKeyword
+	tokenInterpolation
+	tokenString
+	tokenEscape
+	tokenURL
+	tokenLParen
+	tokenRParen
+	tokenLCurly
+	tokenRCurly
+	tokenLBracket
+	tokenRBracket
+	tokenSemicolon
+	tokenColon
+	tokenComma
+	tokenEquals
+	tokenSlash
+)
+
+var tokenKindNames = map[tokenKind]string{
+	tokenError:         "error",
+	tokenWord:          "word",
+	tokenAtKeyword:     "at-keyword",
+	tokenInterpolation: "interpolation",
+	tokenString:        "string",
+	tokenEscape:        "escaped string",
+	tokenURL:           "url",
+	tokenLParen:        "(",
+	tokenRParen:        ")",
+	tokenLCurly:        "{",
+	tokenRCurly:        "}",
+	tokenLBracket:      "[",
+	tokenRBracket:      "]",
+	tokenSemicolon:     ";",
+	tokenColon:         ":",
+	tokenComma:         ",",
+	tokenEquals:        "=",
+	tokenSlash:         "/",
+}
+
+var punctuation = map[rune]tokenKind{
+	'(': tokenLParen,
+	')': tokenRParen,
+	'{': tokenLCurly,
+	'}': tokenRCurly,
+	'[': tokenLBracket,
+	']': tokenRBracket,
+	';': tokenSemicolon,
+	':': tokenColon,
+	',': tokenComma,
+	'=': tokenEquals,
+}
+
+const eof rune = -1
+
+func (k tokenKind) String() string {
+	if name, ok := tokenKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+type position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// A token is a single lexical element of a LESS file. Tokens of kind tokenError carry a description of
+// the problem (an unterminated string or comment, for example) as their value.
+type token struct {
+	kind  tokenKind
+	value string
+	pos   position
+}
+
+func (t token) String() string {
+	if t.kind == tokenError {
+		return fmt.Sprintf("%s: %s", t.pos, t.value)
+	}
+	return fmt.Sprintf("%s: %s %q", t.pos, t.kind, t.value)
+}
+
+type lexer struct {
+	input []byte
+
+	start    int
+	startPos position
+
+	offset int
+	pos    position
+
+	tokens []token
+}
+
+// tokenize splits a LESS file into tokens. Comments and whitespace are dropped; problems like unterminated
+// strings are reported as tokenError tokens so callers can decide whether they care.
+func tokenize(in []byte) []token {
+	l := &lexer{
+		input: in,
+		pos:   position{Line: 1, Column: 1},
+	}
+	l.startPos = l.pos
+
+	l.run()
+
+	return l.tokens
+}
+
+func (l *lexer) run() {
+	for {
+		r := l.peek(0)
+
+		switch {
+		case r == eof:
+			return
+
+		case unicode.IsSpace(r):
+			l.next()
+			l.ignore()
+
+		case r == '/' && l.peek(1) == '/':
+			l.lexLineComment()
+
+		case r == '/' && l.peek(1) == '*':
+			l.lexBlockComment()
+
+		case r == '/':
+			l.next()
+			l.emit(tokenSlash)
+
+		case r == '"' || r == '\'' || r == '`':
+			l.lexString(tokenString)
+
+		case r == '~' && (l.peek(1) == '"' || l.peek(1) == '\'' || l.peek(1) == '`'):
+			l.next()
+			l.lexString(tokenEscape)
+
+		case r == '@' && l.peek(1) == '{':
+			l.lexInterpolation()
+
+		case r == '@':
+			l.lexAtKeyword()
+
+		default:
+			if kind, ok := punctuation[r]; ok {
+				l.next()
+				l.emit(kind)
+				continue
+			}
+
+			l.lexWord()
+		}
+	}
+}
+
+func (l *lexer) peek(n int) rune {
+	offset := l.offset
+	for i := 0; ; i++ {
+		if offset >= len(l.input) {
+			return eof
+		}
+
+		r, width := utf8.DecodeRune(l.input[offset:])
+		if i == n {
+			return r
+		}
+		offset += width
+	}
+}
+
+func (l *lexer) next() rune {
+	if l.offset >= len(l.input) {
+		return eof
+	}
+
+	r, width := utf8.DecodeRune(l.input[l.offset:])
+	l.offset += width
+
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+
+	return r
+}
+
+func (l *lexer) ignore() {
+	l.start = l.offset
+	l.startPos = l.pos
+}
+
+func (l *lexer) emit(kind tokenKind) {
+	l.tokens = append(l.tokens, token{
+		kind:  kind,
+		value: string(l.input[l.start:l.offset]),
+		pos:   l.startPos,
+	})
+	l.ignore()
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) {
+	l.tokens = append(l.tokens, token{
+		kind:  tokenError,
+		value: fmt.Sprintf(format, args...),
+		pos:   l.startPos,
+	})
+	l.ignore()
+}
+
+func (l *lexer) lexLineComment() {
+	for {
+		r := l.peek(0)
+		if r == eof || r == '\n' || r == '\r' {
+			break
+		}
+		l.next()
+	}
+	l.ignore()
+}
+
+func (l *lexer) lexBlockComment() {
+	l.next()
+	l.next()
+
+	for {
+		switch l.next() {
+		case eof:
+			l.errorf("unterminated comment")
+			return
+		case '*':
+			if l.peek(0) == '/' {
+				l.next()
+				l.ignore()
+				return
+			}
+		}
+	}
+}
+
+// lexString reads a quoted string starting at the current rune, honoring backslash escapes. CSS doesn't
+// allow raw newlines inside strings, so an unescaped newline ends the string with an error.
+func (l *lexer) lexString(kind tokenKind) {
+	quote := l.next()
+
+	for {
+		switch r := l.peek(0); r {
+		case eof, '\n', '\r':
+			l.errorf("unterminated string")
+			return
+
+		case '\\':
+			l.next()
+			if l.peek(0) != eof {
+				l.next()
+			}
+
+		case quote:
+			l.next()
+			l.emit(kind)
+			return
+
+		default:
+			l.next()
+		}
+	}
+}
+
+func (l *lexer) lexInterpolation() {
+	l.next()
+	l.next()
+
+	for {
+		switch l.peek(0) {
+		case eof, '\n', '\r':
+			l.errorf("unterminated variable interpolation")
+			return
+
+		case '}':
+			l.next()
+			l.emit(tokenInterpolation)
+			return
+
+		default:
+			l.next()
+		}
+	}
+}
+
+func (l *lexer) lexAtKeyword() {
+	l.next()
+
+	// @@name refers to the variable whose name is the value of @name
+	if l.peek(0) == '@' {
+		l.next()
+	}
+
+	for isNameRune(l.peek(0)) {
+		l.next()
+	}
+
+	l.emit(tokenAtKeyword)
+}
+
+func (l *lexer) lexWord() {
+	for {
+		r := l.peek(0)
+
+		switch {
+		case r == eof, unicode.IsSpace(r), r == '/', r == '"', r == '\'', r == '`':
+			l.finishWord()
+			return
+
+		case r == '@' && l.peek(1) == '{':
+			l.finishWord()
+			return
+
+		case r == '~' && (l.peek(1) == '"' || l.peek(1) == '\'' || l.peek(1) == '`'):
+			l.finishWord()
+			return
+
+		case r == '\\':
+			l.next()
+			if l.peek(0) != eof {
+				l.next()
+			}
+
+		default:
+			if _, ok := punctuation[r]; ok {
+				l.finishWord()
+				return
+			}
+			l.next()
+		}
+	}
+}
+
+func (l *lexer) finishWord() {
+	word := string(l.input[l.start:l.offset])
+	if strings.EqualFold(word, "url") && l.peek(0) == '(' {
+		l.lexURL()
+		return
+	}
+
+	l.emit(tokenWord)
+}
+
+// lexURL reads the body of a url(...) as a single token, since unquoted urls can contain characters (like
+// "//") that would otherwise be lexed as something else.
+func (l *lexer) lexURL() {
+	l.next()
+
+	for {
+		switch r := l.peek(0); r {
+		case eof:
+			l.errorf("unterminated url()")
+			return
+
+		case '"', '\'':
+			quote := l.next()
+			for {
+				r := l.peek(0)
+				if r == eof || r == '\n' || r == '\r' {
+					l.errorf("unterminated string")
+					return
+				}
+
+				l.next()
+				if r == '\\' && l.peek(0) != eof {
+					l.next()
+				} else if r == quote {
+					break
+				}
+			}
+
+		case '\\':
+			l.next()
+			if l.peek(0) != eof {
+				l.next()
+			}
+
+		case ')':
+			l.next()
+			l.emit(tokenURL)
+			return
+
+		default:
+			l.next()
+		}
+	}
+}
+
+func isNameRune(r rune) bool {
+	return r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || r >= utf8.RuneSelf
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in test/golden")
+
+// lessFixtures returns the contents of every .less file in test/less, keyed by path relative to it.
+func lessFixtures(t testing.TB) map[string][]byte {
+	fixtures := map[string][]byte{}
+	root := filepath.Join("test", "less")
+
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || filepath.Ext(path) != ".less" {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		fixtures[filepath.ToSlash(rel)] = contents
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't read fixtures: %s", err)
+	}
+
+	return fixtures
+}
+
+func checkGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+
+	path := filepath.Join("test", "golden", filepath.FromSlash(name)+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can't read golden file (run with -update to create it): %s", err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s doesn't match %s:\n%s", name, path, actual)
+	}
+}
+
+func TestTokenizeGolden(t *testing.T) {
+	for name, contents := range lessFixtures(t) {
+		if strings.HasPrefix(name, "_bootstrap/") {
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		for _, tok := range tokenize(contents) {
+			fmt.Fprintln(buf, tok)
+		}
+
+		checkGolden(t, "tokens/"+name, buf.Bytes())
+	}
+}
+
+func TestTokenizeEdgeCases(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{`"at the end"`, []string{`1:1: string "\"at the end\""`}},
+		{`'a'`, []string{`1:1: string "'a'"`}},
+		{`"\"escaped\""`, []string{`1:1: string "\"\\\"escaped\\\"\""`}},
+		{`/* at the end */`, nil},
+		{`/**/a`, []string{`1:5: word "a"`}},
+		{"// line comment", nil},
+		{`url(//example.com/a.png)`, []string{`1:1: url "url(//example.com/a.png)"`}},
+		{`~"a // b"`, []string{`1:1: escaped string "~\"a // b\""`}},
+		{`.a-@{b}`, []string{`1:1: word ".a-"`, `1:4: interpolation "@{b}"`}},
+		{`@@name`, []string{`1:1: at-keyword "@@name"`}},
+		{`"open`, []string{`1:1: unterminated string`}},
+		{"\"open\nb", []string{`1:1: unterminated string`, `2:1: word "b"`}},
+		{`/* open`, []string{`1:1: unterminated comment`}},
+		{`url(open`, []string{`1:1: unterminated url()`}},
+		{`@{open`, []string{`1:1: unterminated variable interpolation`}},
+		{"a\n  ⌘b;", []string{`1:1: word "a"`, `2:3: word "⌘b"`, `2:5: ; ";"`}},
+	}
+
+	for _, test := range tests {
+		actual := []string{}
+		for _, tok := range tokenize([]byte(test.in)) {
+			actual = append(actual, tok.String())
+		}
+
+		if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("tokenize(%q):\nexpected: %q\nactual:   %q", test.in, test.expected, actual)
+		}
+	}
+}
+
+// byteOffset converts a line/column position back into a byte offset, counting columns the same way the
+// lexer does.
+func byteOffset(in []byte, pos position) int {
+	offset := 0
+	for line := 1; line < pos.Line; line++ {
+		i := bytes.IndexByte(in[offset:], '\n')
+		if i < 0 {
+			return -1
+		}
+		offset += i + 1
+	}
+
+	for col := 1; col < pos.Column; col++ {
+		if offset >= len(in) {
+			return -1
+		}
+		_, width := utf8.DecodeRune(in[offset:])
+		offset += width
+	}
+
+	return offset
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, contents := range lessFixtures(f) {
+		f.Add(contents)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		tokens := tokenize(in)
+
+		last := position{}
+		for _, tok := range tokens {
+			if tok.pos.Line < last.Line || (tok.pos.Line == last.Line && tok.pos.Column <= last.Column) {
+				t.Fatalf("token %s doesn't come after %s", tok, last)
+			}
+			last = tok.pos
+
+			if tok.kind == tokenError {
+				continue
+			}
+
+			if tok.value == "" {
+				t.Fatalf("empty token at %s", tok.pos)
+			}
+
+			if _, ok := punctuation[[]rune(tok.value)[0]]; ok && len(tok.value) != 1 {
+				t.Fatalf("punctuation token %s is more than one character", tok)
+			}
+
+			offset := byteOffset(in, tok.pos)
+			if offset < 0 || !bytes.HasPrefix(in[offset:], []byte(tok.value)) {
+				t.Fatalf("token %s doesn't match the input at its position", tok)
+			}
+		}
+	})
+}
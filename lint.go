@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type diagnostic struct {
+	Path    string
+	Pos     position
+	Message string
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s:%s: %s", displayPath(d.Path), d.Pos, d.Message)
+}
+
+func init() {
+	commands["lint"] = &command{
+		args:        "<dir> <another-dir>...",
+		description: "Check LESS files for syntax errors, bad imports and undefined variables without running lessc",
+		run:         lintCommand,
+	}
+}
+
+func lintCommand(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: less-tree lint <dir> <another-dir>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitEnvironment
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "less-tree: can't find the working directory")
+		return exitEnvironment
+	}
+	workingDirectory = wd
+
+	problems := 0
+	for _, dir := range fs.Args() {
+		root, _ := filepath.Abs(dir)
+
+		g, err := newImportGraph(root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+			return exitEnvironment
+		}
+
+		diagnostics := lint(g)
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+		problems += len(diagnostics)
+	}
+
+	if isVerbose {
+		fmt.Printf("%d problems found\n", problems)
+	}
+
+	if problems > 0 {
+		return exitCompile
+	}
+
+	return exitOK
+}
+
+// lint checks every LESS file in the graph and returns the problems it finds, sorted by file and position.
+func lint(g *importGraph) []diagnostic {
+	diagnostics := []diagnostic{}
+
+	for _, f := range g.sorted() {
+		if !strings.EqualFold(filepath.Ext(f.Path), ".less") {
+			continue
+		}
+
+		diagnostics = append(diagnostics, lintFile(f)...)
+	}
+
+	// Variables in an include are often defined by whatever imports it, so they're checked against
+	// everything that ends up in each entry point's compilation.
+	reported := map[diagnostic]bool{}
+	for _, entry := range g.entries() {
+		closure := g.closure(entry)
+
+		defined := map[string]bool{}
+		for _, f := range closure {
+			for _, ref := range findSymbols(f.tokens) {
+				if ref.Kind == symbolVariable && ref.Def {
+					defined[ref.Name] = true
+				}
+			}
+		}
+
+		for _, f := range closure {
+			for _, ref := range findSymbols(f.tokens) {
+				if ref.Kind != symbolVariable || ref.Def || defined[ref.Name] || builtinVariables[ref.Name] {
+					continue
+				}
+
+				d := diagnostic{
+					Path:    f.Path,
+					Pos:     ref.Pos,
+					Message: fmt.Sprintf("undefined variable %s", ref.Name),
+				}
+				if reported[d] {
+					continue
+				}
+				reported[d] = true
+
+				if f != entry {
+					d.Message += fmt.Sprintf(" (when compiling %s)", entry.Name)
+				}
+				diagnostics = append(diagnostics, d)
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		return a.Pos.Column < b.Pos.Column
+	})
+
+	return diagnostics
+}
+
+func lintFile(f *graphFile) []diagnostic {
+	diagnostics := []diagnostic{}
+	report := func(pos position, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, diagnostic{
+			Path:    f.Path,
+			Pos:     pos,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if f.err != nil {
+		report(position{Line: 1, Column: 1}, "%s", f.err)
+		return diagnostics
+	}
+
+	for _, tok := range f.tokens {
+		if tok.kind == tokenError {
+			report(tok.pos, "%s", tok.value)
+		}
+	}
+
+	// A missing closer leaves every opener around it unclosed too, but it's the innermost one that's
+	// missing it, so that's the only one reported.
+	for _, pair := range [][2]tokenKind{{tokenLCurly, tokenRCurly}, {tokenLParen, tokenRParen}} {
+		opener, closer := pair[0], pair[1]
+
+		depth := 0
+		var unclosed *token
+		for i, tok := range f.tokens {
+			switch tok.kind {
+			case opener:
+				depth++
+				if _, err := sliceUntilMatching(f.tokens, opener, closer, i, 0); err != nil {
+					unclosed = &f.tokens[i]
+				}
+
+			case closer:
+				depth--
+				if depth < 0 {
+					report(tok.pos, "unexpected %s", closer)
+					depth = 0
+				}
+			}
+		}
+
+		if unclosed != nil {
+			report(unclosed.pos, "unclosed %s", opener)
+		}
+	}
+
+	imported := map[*graphFile]position{}
+	for _, imp := range f.imports {
+		if imp.err != nil {
+			report(imp.pos, "%s", imp.err)
+			continue
+		}
+
+		if imp.file == nil || imp.hasOption("multiple") {
+			continue
+		}
+
+		if first, exists := imported[imp.file]; exists {
+			report(imp.pos, "%s is already imported on line %d", imp.Path, first.Line)
+			continue
+		}
+		imported[imp.file] = imp.pos
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	root, _ := filepath.Abs(filepath.Join("test", "lint"))
+	defer func(wd string) { workingDirectory = wd }(workingDirectory)
+	workingDirectory = root
+
+	g, err := newImportGraph(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"less/imports.less:2:1: import path _missing is not valid: stat " + filepath.ToSlash(filepath.Join(root, "less", "_missing.less")) + ": no such file or directory",
+		"less/imports.less:3:1: _vars.less is already imported on line 1",
+		"less/nested.less:2:6: unclosed {",
+		"less/stray.less:2:21: unexpected )",
+		"less/unbalanced.less:1:4: unclosed {",
+		"less/undefined.less:5:15: undefined variable @background",
+	}
+
+	actual := []string{}
+	for _, d := range lint(g) {
+		actual = append(actual, filepath.ToSlash(d.String()))
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\n\nactual:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockPollInterval is how often a run waiting on another one's lock checks whether it's been released.
+const lockPollInterval = 100 * time.Millisecond
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// A rootLock is an advisory lock on a css root, held for the length of a run so two less-tree processes
+// building the same root don't race on its outputs or its cache file.
+type rootLock struct {
+	path string
+	file *os.File
+}
+
+// acquireLock takes the lock file at path for the root dir (see stateFor). If another process already
+// holds it, it waits up to timeout (forever if timeout is 0, or until ctx is cancelled) for it to be
+// released, or fails straight away if wait is false.
+func acquireLock(ctx context.Context, path, dir string, timeout time.Duration, wait bool) (*rootLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("can't lock %s: %s", path, err)
+	}
+
+	start := time.Now()
+	warned := false
+	for {
+		f, err := tryLock(path)
+		if err == nil {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return &rootLock{path: path, file: f}, nil
+		}
+
+		if err != errLocked {
+			return nil, fmt.Errorf("can't lock %s: %s", path, err)
+		}
+
+		holder := lockHolder(path)
+		if !wait {
+			return nil, fmt.Errorf("%s is locked by another less-tree run%s", dir, holder)
+		}
+
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, fmt.Errorf("timed out after %s waiting for another less-tree run%s to finish with %s", timeout, holder, dir)
+		}
+
+		if !warned {
+			log.with("lock", path).warnf("waiting for another less-tree run%s to finish with %s", holder, dir)
+			warned = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// lockHolder describes the process holding the lock at path, if it can tell.
+func lockHolder(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf(" (pid %d)", pid)
+}
+
+// Release gives up the lock. It's safe to call on a nil lock, or more than once.
+func (l *rootLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	err := unlock(l.path, l.file)
+	l.file = nil
+
+	return err
+}
@@ -0,0 +1,27 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"os"
+)
+
+// tryLock creates the file at path, failing if it already exists. Unlike flock, the lock outlives a
+// crashed run; delete the file by hand if that happens.
+func tryLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func unlock(path string, f *os.File) error {
+	f.Close()
+	return os.Remove(path)
+}
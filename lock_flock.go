@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an flock on the file at path. The kernel drops it if the process dies, so a crashed run
+// never leaves a root locked.
+func tryLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// unlock releases the lock. The file is left in place: removing it would let another process lock a
+// new file at the same path while a third still holds the old one.
+func unlock(path string, f *os.File) error {
+	f.Truncate(0)
+	return f.Close()
+}
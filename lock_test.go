@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".less-tree-lock")
+
+	lock, err := acquireLock(context.Background(), path, dir, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := acquireLock(context.Background(), path, dir, 0, false); err == nil {
+		t.Fatal("expected a second lock to fail straight away")
+	}
+
+	if _, err := acquireLock(context.Background(), path, dir, 200*time.Millisecond, true); err == nil {
+		t.Fatal("expected a second lock to time out")
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lock.Release()
+	}()
+
+	again, err := acquireLock(context.Background(), path, dir, 5*time.Second, true)
+	if err != nil {
+		t.Fatalf("expected to get the lock once it was released, got %v", err)
+	}
+	again.Release()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A logLevel is how much less-tree says about what it's doing.
+type logLevel int
+
+const (
+	levelError   logLevel = iota // only what went wrong
+	levelWarn                    // -q: warnings too
+	levelInfo                    // the default: the summary and progress too
+	levelVerbose                 // -v: what's rebuilt and why, and each file as it's built
+	levelDebug                   // -vv: the analysis and scheduling too
+)
+
+var levelNames = map[logLevel]string{
+	levelError:   "error",
+	levelWarn:    "warning",
+	levelInfo:    "info",
+	levelVerbose: "verbose",
+	levelDebug:   "debug",
+}
+
+// A logger writes log lines, either as text or as JSON objects, one per line. Errors and warnings go to
+// stderr and everything else to stdout.
+type logger struct {
+	level      logLevel
+	json       bool
+	timestamps bool
+
+	mu sync.Mutex
+}
+
+// A logEntry is a log line that's being put together, with the fields that give it context, like the
+// file it's about.
+type logEntry struct {
+	l      *logger
+	fields []logField
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// log is the logger for the run, set up from the flags in main.
+var log = &logger{level: levelInfo}
+
+// enabled reports whether lines at level are written.
+func (l *logger) enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+// with starts an entry with the field key set to value.
+func (l *logger) with(key string, value interface{}) *logEntry {
+	return &logEntry{l: l, fields: []logField{{key, value}}}
+}
+
+// file starts an entry about the entry point or LESS file name.
+func (l *logger) file(name string) *logEntry {
+	return l.with("file", name)
+}
+
+func (l *logger) errorf(format string, args ...interface{}) {
+	l.log(levelError, nil, format, args...)
+}
+
+func (l *logger) warnf(format string, args ...interface{}) {
+	l.log(levelWarn, nil, format, args...)
+}
+
+func (l *logger) infof(format string, args ...interface{}) {
+	l.log(levelInfo, nil, format, args...)
+}
+
+func (l *logger) verbosef(format string, args ...interface{}) {
+	l.log(levelVerbose, nil, format, args...)
+}
+
+func (l *logger) debugf(format string, args ...interface{}) {
+	l.log(levelDebug, nil, format, args...)
+}
+
+// with adds the field key to the entry.
+func (e *logEntry) with(key string, value interface{}) *logEntry {
+	return &logEntry{l: e.l, fields: append(e.fields[:len(e.fields):len(e.fields)], logField{key, value})}
+}
+
+func (e *logEntry) errorf(format string, args ...interface{}) {
+	e.l.log(levelError, e.fields, format, args...)
+}
+
+func (e *logEntry) warnf(format string, args ...interface{}) {
+	e.l.log(levelWarn, e.fields, format, args...)
+}
+
+func (e *logEntry) infof(format string, args ...interface{}) {
+	e.l.log(levelInfo, e.fields, format, args...)
+}
+
+func (e *logEntry) verbosef(format string, args ...interface{}) {
+	e.l.log(levelVerbose, e.fields, format, args...)
+}
+
+func (e *logEntry) debugf(format string, args ...interface{}) {
+	e.l.log(levelDebug, e.fields, format, args...)
+}
+
+func (l *logger) log(level logLevel, fields []logField, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	now := time.Now()
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	var line []byte
+	if l.json {
+		line = l.formatJSON(now, level, fields, msg)
+	} else {
+		line = l.formatText(now, level, msg)
+	}
+
+	var w io.Writer = stdout
+	if level <= levelWarn {
+		w = stderr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w.Write(line)
+}
+
+// formatText formats a line for people to read. The fields are left out, since the message should
+// already say what it's about.
+func (l *logger) formatText(now time.Time, level logLevel, msg string) []byte {
+	buf := &bytes.Buffer{}
+	if l.timestamps {
+		buf.WriteString(now.Format("15:04:05.000 "))
+	}
+
+	switch level {
+	case levelError, levelWarn:
+		buf.WriteString(levelNames[level] + ": ")
+	}
+
+	buf.WriteString(msg)
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+// formatJSON formats a line as a JSON object, with the time, level and message first and then the
+// fields in the order they were added.
+func (l *logger) formatJSON(now time.Time, level logLevel, fields []logField, msg string) []byte {
+	fields = append([]logField{
+		{"time", now.Format(time.RFC3339Nano)},
+		{"level", levelNames[level]},
+		{"msg", strings.TrimSpace(msg)},
+	}, fields...)
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(jsonValue(f.value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+// jsonValue converts the values that don't marshal usefully on their own: durations become seconds and
+// errors their messages.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return strings.TrimSpace(v.Error())
+	}
+
+	return v
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLogFormat(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fields := []logField{{"file", "admin.less"}, {"duration", 1500 * time.Millisecond}, {"error", errors.New("bad thing\n")}}
+
+	l := &logger{}
+	if line := string(l.formatText(now, levelWarn, "admin.less: slow")); line != "warning: admin.less: slow\n" {
+		t.Errorf("unexpected text line %q", line)
+	}
+	if line := string(l.formatText(now, levelVerbose, "build: admin.less")); line != "build: admin.less\n" {
+		t.Errorf("unexpected text line %q", line)
+	}
+
+	l.timestamps = true
+	if line := string(l.formatText(now, levelError, "oops")); line != "03:04:05.000 error: oops\n" {
+		t.Errorf("unexpected text line %q", line)
+	}
+
+	line := l.formatJSON(now, levelError, fields, "admin.less failed\n")
+	expected := `{"time":"2026-01-02T03:04:05Z","level":"error","msg":"admin.less failed","file":"admin.less","duration":1.5,"error":"bad thing"}` + "\n"
+	if string(line) != expected {
+		t.Errorf("expected %s, got %s", expected, line)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(line, &decoded); err != nil {
+		t.Errorf("expected valid JSON: %s", err)
+	}
+}
+
+func TestLogEntryFields(t *testing.T) {
+	base := log.file("a.less")
+	one := base.with("step", "lessc")
+	two := base.with("step", "cssmin")
+
+	if one.fields[1].value != "lessc" || two.fields[1].value != "cssmin" {
+		t.Errorf("expected entries made from the same one to have their own fields, got %v and %v", one.fields, two.fields)
+	}
+}
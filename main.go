@@ -4,25 +4,53 @@ import (
 	"github.com/jimmysawczuk/worker"
 	"github.com/pkg/errors"
 
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var pathToLessc string
 var lesscArgs lesscArg
+var cmdVars modifyVars
+var configPath string
+var cfg *config
+var fingerprint *buildFingerprint
+var cacheDir string
+var cacheURL string
+var cacheMode string
+var sharedCache buildCache
 var pathToCSSMin string
 var workingDirectory string
 var isVerbose bool
+var veryVerbose bool
+var debug bool
+var quiet bool
+var logJSON bool
 var enableCSSMin bool
-var force bool
-var maxJobs = 4
+var force forceFlag
+var paranoid bool
+var stateDir string
+var lockTimeout = 5 * time.Minute
+var noWait bool
+var failFast bool
+var jobTimeout time.Duration
+var schedulePolicy = "duration"
+var modifiedOutputs = "warn"
+var progressMode = "auto"
+var profilePath string
+var maxJobs = maxJobsFlag{n: runtime.NumCPU()}
 var version = "1.7.0"
 var lessFilename = regexp.MustCompile(`^([A-Za-z0-9_\-\.]+)\.less$`)
 
@@ -31,13 +59,47 @@ type lesscArg struct {
 	out []string
 }
 
+// A forceFlag is -f, which either forces everything to be rebuilt or, given patterns, just the entry
+// points (named relative to the less directory) that match one of them.
+type forceFlag struct {
+	all      bool
+	patterns globList
+
+	mu      sync.Mutex
+	matched map[string]bool
+}
+
+// A globList is a flag that can be repeated to give several glob patterns.
+type globList []string
+
 func init() {
 	flag.StringVar(&pathToLessc, "lessc-path", "", "Path to the lessc executable")
 	flag.Var(&lesscArgs, "lessc-args", "Any extra arguments/flags to pass to lessc before the paths (specified as a JSON array)")
-
-	flag.BoolVar(&isVerbose, "v", false, "Whether or not to show LESS errors")
-	flag.IntVar(&maxJobs, "max-jobs", maxJobs, "Maximum amount of jobs to run at once")
-	flag.BoolVar(&force, "f", false, "If true, all CSS will be rebuilt regardless of whether or not the source LESS file(s) changed")
+	flag.Var(&cmdVars, "var", "A variable to pass to lessc with --modify-var, formatted as name=value (can be repeated)")
+	flag.StringVar(&configPath, "config", "", "Path to a JSON file with per-root and per-entry settings (defaults to "+defaultConfigPath+" if it exists)")
+
+	flag.BoolVar(&isVerbose, "v", false, "Show what's rebuilt and why, and each file as it's built")
+	flag.BoolVar(&veryVerbose, "vv", false, "Like -v, and show how the files are analyzed and scheduled too")
+	flag.BoolVar(&debug, "debug", false, "Like -vv, with a timestamp on every line")
+	flag.BoolVar(&quiet, "q", false, "Only show errors and warnings")
+	flag.BoolVar(&logJSON, "log-json", false, "Write log lines as JSON objects, with the file each one is about in a field of its own")
+	flag.Var(&maxJobs, "max-jobs", "Maximum amount of jobs to run at once, or auto to adjust it to how fast they're going and how much memory is free")
+	flag.StringVar(&schedulePolicy, "schedule", schedulePolicy, "Which entry points to compile first: duration (the ones that took longest last time), mtime (the ones with the most recently edited files) or fifo (in the order they're found)")
+	flag.BoolVar(&failFast, "fail-fast", false, "Stop after the first entry point that fails, cancelling the rest")
+	flag.Var(keepGoingFlag{&failFast}, "k", "Keep going after an entry point fails, building everything else (the default; undoes -fail-fast)")
+	flag.DurationVar(&jobTimeout, "job-timeout", 0, "How long to let lessc and cssmin run on a single file before killing them and counting it as an error, like 2m (0 means no limit)")
+	flag.Var(&force, "f", "If true, all CSS will be rebuilt regardless of whether or not the source LESS file(s) changed; -f=pattern only rebuilds the entry points matching pattern, like admin/**")
+	flag.Var(&force.patterns, "rebuild", "An entry point or pattern to rebuild regardless of whether or not it changed, like -f=pattern (can be repeated)")
+	flag.StringVar(&cacheDir, "cache-dir", os.Getenv("LESS_TREE_CACHE"), "Directory for a build cache shared between checkouts, so identical builds are restored instead of compiled (defaults to $LESS_TREE_CACHE)")
+	flag.StringVar(&cacheURL, "cache-url", os.Getenv("LESS_TREE_CACHE_URL"), "URL of a shared build cache server, like one run with less-tree serve-cache (defaults to $LESS_TREE_CACHE_URL)")
+	flag.StringVar(&cacheMode, "cache-mode", envOrDefault("LESS_TREE_CACHE_MODE", "read"), "Whether to only read from the -cache-url server (read) or upload new builds to it too (write)")
+	flag.StringVar(&stateDir, "state-dir", os.Getenv("LESS_TREE_STATE_DIR"), "Directory to keep each root's cache file and lock in, instead of its css directory (defaults to $LESS_TREE_STATE_DIR)")
+	flag.DurationVar(&lockTimeout, "lock-timeout", lockTimeout, "How long to wait for another less-tree run on the same directory to finish (0 waits forever)")
+	flag.BoolVar(&noWait, "no-wait", false, "Fail straight away if another less-tree run is using the same directory")
+	flag.StringVar(&modifiedOutputs, "modified-outputs", modifiedOutputs, "What to do with CSS files changed since less-tree wrote them: warn, keep (never overwrite them) or rebuild")
+	flag.StringVar(&progressMode, "progress", progressMode, "How to show progress: auto (tty on a terminal, plain otherwise), tty (a live view of the running files, the counts and an ETA), plain (a line per file) or none")
+	flag.StringVar(&profilePath, "profile", "", "Write a trace of where the time went (crawling, hashing, tokenizing, resolving imports, testing the cache, lessc and cssmin) to this file, like out.json, for chrome://tracing or ui.perfetto.dev")
+	flag.BoolVar(&paranoid, "paranoid", false, "Hash every LESS file, even ones whose size and modification time haven't changed since the last run")
 
 	flag.BoolVar(&enableCSSMin, "min", false, "Automatically minify outputted css files")
 	flag.StringVar(&pathToCSSMin, "cssmin-path", "", "Path to cssmin (or an executable which takes an input file as an argument and spits out minified CSS in stdout)")
@@ -45,19 +107,26 @@ func init() {
 	flag.Usage = func() {
 		versions()
 		fmt.Printf("Usage: less-tree [options] <dir> <another-dir>...\n")
+		fmt.Printf("       less-tree [options] <command> [arguments]\n\n")
+		commandUsage()
+		fmt.Printf("A directory with the same name as a command is built rather than running the command.\n\n")
+		fmt.Printf("Options:\n")
 		flag.PrintDefaults()
+		fmt.Printf("\nExit status:\n")
+		fmt.Printf("  %d if everything was built, %d if any LESS file failed to compile, %d for a problem with the\n", exitOK, exitCompile, exitEnvironment)
+		fmt.Printf("  options, config, lessc or directories, %d for an internal error and %d plus the signal's number if\n", exitInternal, exitSignal)
+		fmt.Printf("  interrupted (130 for Ctrl-C, 143 for SIGTERM)\n")
 	}
 }
 
 func versions() {
-	cmd := exec.Command(pathToLessc, "-v")
-	lesscVersion, err := cmd.CombinedOutput()
-	if err != nil {
-		lesscVersion = []byte("lessc not found!")
+	lesscVersion := lesscVersion()
+	if lesscVersion == "" {
+		lesscVersion = "lessc not found!"
 	}
 
 	fmt.Printf("less-tree v%s\n", version)
-	fmt.Printf(" - lessc (%s): %s\n", pathToLessc, strings.TrimSpace(string(lesscVersion)))
+	fmt.Printf(" - lessc (%s): %s\n", pathToLessc, lesscVersion)
 	fmt.Printf(" - cssmin (%s): enabled: %t\n", pathToCSSMin, enableCSSMin)
 	fmt.Printf("\n")
 }
@@ -66,24 +135,120 @@ func main() {
 	start := time.Now()
 
 	flag.Parse()
-	worker.MaxJobs = maxJobs
+	worker.MaxJobs = maxJobs.slots()
+
+	switch {
+	case debug:
+		log.level, log.timestamps = levelDebug, true
+	case veryVerbose:
+		log.level = levelDebug
+	case isVerbose:
+		log.level = levelVerbose
+	case quiet:
+		log.level = levelWarn
+	}
+	log.json = logJSON
+	isVerbose = log.enabled(levelVerbose)
+
+	if cmd := subcommand(flag.Arg(0)); cmd != nil {
+		os.Exit(cmd.run(flag.Args()[1:]))
+		return
+	}
 
 	err := validateEnvironment()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, errors.Wrap(err, "less-tree"))
+		log.errorf("%s", err)
+		os.Exit(exitEnvironment)
+		return
+	}
 
-		// these are all path errors, so exit(1) should be okay/meaningful.
-		os.Exit(1)
+	cfg, err = loadGlobalConfig()
+	if err != nil {
+		log.errorf("%s", err)
+		os.Exit(exitEnvironment)
 		return
 	}
 
-	if isVerbose {
+	if stateDir == "" {
+		stateDir = cfg.StateDir
+	}
+
+	switch modifiedOutputs {
+	case "warn", "keep", "rebuild":
+	default:
+		log.errorf("invalid -modified-outputs %q (should be warn, keep or rebuild)", modifiedOutputs)
+		os.Exit(exitEnvironment)
+		return
+	}
+
+	view, err := newProgressView(progressMode)
+	if err != nil {
+		log.errorf("%s", err)
+		os.Exit(exitEnvironment)
+		return
+	}
+
+	if isVerbose && !log.json {
 		versions()
 	}
 
+	if profilePath != "" {
+		trace = newTracer()
+	}
+
+	fingerprint = newBuildFingerprint()
+
+	sharedCache, err = openSharedCache(cacheDir, cacheURL, cacheMode)
+	if err != nil {
+		log.errorf("%s", err)
+		os.Exit(exitEnvironment)
+		return
+	}
+
+	// The first interrupt cancels the run: running lessc processes are killed, nothing new is started
+	// and the cache is saved with whatever did finish. A second one exits straight away. The signal is
+	// kept for the exit code.
+	ctx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var interruptedBy os.Signal
+	go func() {
+		interruptedBy = <-signals
+		signal.Stop(signals)
+		interrupt()
+		log.warnf("interrupted, stopping (interrupt again to quit now)")
+	}()
+
+	// -fail-fast cancels buildCtx rather than ctx, so it can be told apart from an interrupt.
+	buildCtx, cancelBuild := context.WithCancel(ctx)
+	defer cancelBuild()
+	status := newRunStatus(cancelBuild, failFast)
+
 	cssQueue := worker.NewWorker()
+	var restored, cancelled, timedOut int64
 	cssQueue.On(worker.JobFinished, func(pk *worker.Package, args ...interface{}) {
-		job := pk.Job().(*cssJob)
+		job := pk.Job().(*scheduledJob).job
+
+		if job.restored {
+			atomic.AddInt64(&restored, 1)
+		}
+
+		if job.cancelled {
+			atomic.AddInt64(&cancelled, 1)
+		}
+
+		if job.timedOut {
+			atomic.AddInt64(&timedOut, 1)
+		}
+
+		if job.exitCode != 0 && !job.cancelled {
+			if job.internal {
+				status.fail(exitInternal)
+			} else {
+				status.fail(exitCompile)
+			}
+		}
 
 		if job.exitCode == 0 {
 			pk.SetStatus(worker.Finished)
@@ -92,12 +257,62 @@ func main() {
 		}
 	})
 
+	sched, err := newScheduler(cssQueue, schedulePolicy, newJobLimiter(maxJobs, os.Getenv("MAKEFLAGS")))
+	if err != nil {
+		log.errorf("%s", err)
+		os.Exit(exitEnvironment)
+		return
+	}
+
 	args := flag.Args()
 	for _, v := range args {
-		parseDirectory(v, cssQueue)
+		// -f is a boolean flag, so -f admin/** means -f plus a directory called admin/**
+		if _, err := os.Stat(v); force.all && os.IsNotExist(err) && strings.ContainsAny(v, "*?[") {
+			log.errorf("%s isn't a directory; to only rebuild the entry points matching it, use -f=%s", v, v)
+			os.Exit(exitEnvironment)
+			return
+		}
+	}
+
+	// Compiling starts as soon as the first entry point that needs it has been analyzed, and the roots
+	// are analyzed at the same time.
+	view.watch(cssQueue, sched)
+	view.start()
+
+	stopCh := make(chan worker.ExitCode)
+	go cssQueue.RunUntilStopped(stopCh)
+
+	var wg sync.WaitGroup
+	for _, v := range uniqueRoots(args) {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+
+			build, err := parseDirectory(buildCtx, dir, sched, status)
+			if err == context.Canceled {
+				return
+			} else if err != nil {
+				log.with("root", dir).errorf("%s", err)
+				status.fail(exitEnvironment)
+				return
+			}
+
+			// Each root's lock is released as soon as its own jobs are done, rather than once every
+			// root's are, so two runs given the same roots in a different order can't hold each other up.
+			build.pending.Wait()
+			build.finish(status)
+		}(v)
 	}
+	wg.Wait()
+
+	for _, pattern := range force.unmatched() {
+		log.warnf("%s doesn't match any entry points", pattern)
+	}
+
+	stopCh <- worker.ExitWhenDone
+	<-stopCh
 
-	cssQueue.RunUntilDone()
+	slowest := view.finish()
 
 	finish := time.Now()
 
@@ -109,17 +324,141 @@ func main() {
 			successRate = float64(100*stats.Finished) / float64(stats.Total)
 		}
 
-		if isVerbose {
-			fmt.Println("--------------------------------------")
+		// a separator between the files and the summary, which doesn't mean anything as JSON
+		if !log.json {
+			log.verbosef("--------------------------------------")
+		}
+		errored := stats.Errored - atomic.LoadInt64(&cancelled)
+		log.with("total", stats.Total).with("duration", finish.Sub(start)).infof("Compiled %d LESS files in %s", stats.Total, finish.Sub(start))
+		log.with("ok", stats.Finished).with("errored", errored).infof("%d ok, %d errored (%.1f%% success rate)", stats.Finished, errored, successRate)
+
+		if slowest != "" {
+			log.infof("%s", slowest)
+		}
+
+		if sharedCache != nil {
+			log.with("restored", atomic.LoadInt64(&restored)).infof("%d restored from the shared cache", atomic.LoadInt64(&restored))
 		}
-		fmt.Printf("Compiled %d LESS files in %s\n%d ok, %d errored (%.1f%% success rate)\n",
-			stats.Total,
-			finish.Sub(start).String(),
-			stats.Finished,
-			stats.Errored,
-			successRate,
-		)
+
+		if n := atomic.LoadInt64(&timedOut); n > 0 {
+			log.with("timedOut", n).infof("%d timed out after %s", n, jobTimeout)
+		}
+
+		if buildCtx.Err() != nil {
+			log.with("cancelled", atomic.LoadInt64(&cancelled)).infof("%d cancelled", atomic.LoadInt64(&cancelled))
+		}
+	}
+
+	if trace != nil {
+		if err := trace.Save(profilePath); err != nil {
+			log.errorf("can't write the profile: %s", err)
+			status.fail(exitInternal)
+		} else {
+			log.infof("Wrote the profile to %s", profilePath)
+		}
+	}
+
+	var sig os.Signal
+	if ctx.Err() != nil {
+		sig = interruptedBy
+	}
+	os.Exit(status.exitCode(sig))
+}
+
+// uniqueRoots removes any directories that are given more than once, which would otherwise wait on their
+// own locks.
+func uniqueRoots(dirs []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, dir := range dirs {
+		abs, _ := filepath.Abs(dir)
+		if !seen[abs] {
+			seen[abs] = true
+			unique = append(unique, dir)
+		}
+	}
+
+	return unique
+}
+
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
+	return def
+}
+
+func (f *forceFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *forceFlag) String() string {
+	if f.all {
+		return "true"
+	}
+	return f.patterns.String()
+}
+
+func (f *forceFlag) Set(in string) error {
+	switch in {
+	case "true":
+		f.all = true
+	case "false":
+		f.all = false
+	default:
+		return f.patterns.Set(in)
+	}
+
+	return nil
+}
+
+// matches reports whether the entry point name should be rebuilt, and remembers which patterns matched
+// it.
+func (f *forceFlag) matches(name string) bool {
+	if f.all {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	matched := false
+	for _, pattern := range f.patterns {
+		if matchGlob(pattern, filepath.ToSlash(name)) {
+			if f.matched == nil {
+				f.matched = map[string]bool{}
+			}
+			f.matched[pattern] = true
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// unmatched returns the patterns that haven't matched any entry points.
+func (f *forceFlag) unmatched() []string {
+	unmatched := []string{}
+	for _, pattern := range f.patterns {
+		if !f.matched[pattern] {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+
+	return unmatched
+}
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(in string) error {
+	if _, err := filepath.Match(in, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", in, err)
+	}
+
+	*g = append(*g, filepath.ToSlash(strings.TrimPrefix(in, "./")))
+	return nil
 }
 
 func (a *lesscArg) String() string {
@@ -210,58 +549,156 @@ func validateEnvironment() error {
 	return nil
 }
 
-func parseDirectory(dir string, cssQueue *worker.Worker) {
-	analyzeQueue := worker.NewWorker()
-	lessFileCh := make(chan *lessFile, 100)
-	errCh := make(chan error, 100)
-	stopCh := make(chan bool)
-	files := []*lessFile{}
-
-	crawler, err := newDirectoryCrawler(dir, func(crawler *directoryCrawler, less_dir, css_dir *os.File, less_file os.FileInfo) {
-		name, _ := filepath.Rel(crawler.rootLESS.Name(), filepath.Join(less_dir.Name(), less_file.Name()))
-		job := newFindImportsJob(name, less_dir, css_dir, less_file, lessFileCh, errCh)
-		analyzeQueue.Add(job)
-	})
-	if err != nil {
-		fmt.Printf("error crawling directory %s: %s\n", dir, err)
+// rebuildReason says why an entry point needs to be built, or returns "" if it doesn't. isCached and
+// reason are the cache's verdict on it, and modified are its outputs that have been changed since they
+// were written.
+func rebuildReason(isCached bool, reason string, job *cssJob, modified []string) string {
+	switch {
+	case force.matches(job.Name):
+		return "forced"
+	case isCached && !job.OutputFilesExist():
+		return "output missing"
+	case isCached && len(modified) > 0 && modifiedOutputs == "rebuild":
+		return "output modified"
+	case isCached:
+		return ""
 	}
 
-	cm := newLessTreeCache(crawler.rootCSS)
-	err = cm.Load()
+	return reason
+}
 
-	go func(less_file_ch chan *lessFile, error_ch chan error, stop_ch chan bool) {
-		for {
-			select {
-			case l := <-less_file_ch:
-				files = append(files, l)
+// A rootBuild is everything being built for one root directory, which stays locked until it's finished.
+type rootBuild struct {
+	lock  *rootLock
+	cache *lessTreeCache
+	root  *rootConfig
+	jobs  []*cssJob
 
-			case err := <-error_ch:
-				fmt.Printf("err: %s\n", err)
+	// pending is the jobs that haven't finished yet.
+	pending sync.WaitGroup
+}
 
-			case _ = <-stop_ch:
-				break
+// finish records the outputs of the root's jobs in its cache, saves it and releases the lock. Entries
+// that failed to build are dropped from the cache so they're tried again next time.
+func (b *rootBuild) finish(status *runStatus) {
+	for _, job := range b.jobs {
+		if job.exitCode != 0 {
+			delete(b.cache.Files, job.Name)
+			continue
+		}
+
+		if f := b.cache.Files[job.Name]; f != nil {
+			f.Outputs = job.outputs
+			if job.duration > 0 {
+				f.Duration = job.duration
 			}
 		}
-	}(lessFileCh, errCh, stopCh)
+	}
+
+	if err := b.cache.Save(); err != nil {
+		log.with("cache", displayPath(b.cache.file)).errorf("can't save the cache: %s", err)
+		status.fail(exitInternal)
+	}
+
+	b.lock.Release()
+}
+
+// parseDirectory finds the entry points in dir that need to be built and schedules them as they're
+// found, so the scheduler's queue should already be running. The returned rootBuild should be finished
+// once its pending jobs have been built. Files that can't be analyzed, and directories that can't be
+// crawled, are reported to status.
+func parseDirectory(ctx context.Context, dir string, sched *scheduler, status *runStatus) (*rootBuild, error) {
+	crawler, err := newDirectoryCrawler(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	state := stateFor(crawler.root.Name(), crawler.rootCSS.Name())
+	lock, err := acquireLock(ctx, state.lock, crawler.rootCSS.Name(), lockTimeout, !noWait)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateDir != "" {
+		legacy := filepath.Join(crawler.rootCSS.Name(), ".less-tree-cache")
+		if _, err := os.Stat(legacy); err == nil {
+			log.with("root", dir).warnf("ignoring %s because of -state-dir; run less-tree migrate-cache %s to move it", displayPath(legacy), dir)
+		}
+	}
+
+	cm := newLessTreeCache(state.cache)
+	if err := cm.Load(); err != nil && !os.IsNotExist(err) {
+		log.with("root", dir).warnf("%s", err)
+	}
 
-	crawler.Parse()
+	build := &rootBuild{lock: lock, cache: cm, root: cfg.root(dir)}
 
-	if isVerbose {
-		fmt.Println("finished building queue")
+	crawler.failFunc = func(code int, err error) {
+		log.with("root", dir).errorf("%s", err)
+		status.fail(code)
 	}
 
-	analyzeQueue.RunUntilDone()
-	stopCh <- true
+	// Each file is checked against the cache, and queued to be compiled if it needs to be, as soon as
+	// it's been analyzed.
+	analyzeRoot(ctx, crawler, cm, func(l *lessFile) {
+		build.schedule(ctx, l, sched)
+	}, func(err error) {
+		log.with("root", dir).errorf("%s", err)
+		status.fail(exitCompile)
+	})
+
+	return build, nil
+}
+
+// schedule checks file against the cache and schedules a job to build it if it needs to be.
+func (b *rootBuild) schedule(ctx context.Context, file *lessFile, sched *scheduler) {
+	cm := b.cache
+
+	file.Vars = b.root.varsFor(file.Name, cmdVars)
+	file.Fingerprint = fingerprint
+
+	job := newCSSJob(ctx, file.Name, file.Dir, file.CSSDir, file.File, lesscArgs.out, file.Vars)
+	if sharedCache != nil {
+		job.cacheKey = buildKey(file)
+	}
+
+	prev := cm.Files[file.Name]
+	span := trace.begin("analyze", "cache test", file.Name)
+	isCached, reason := cm.Test(file)
+	modified := job.ModifiedOutputs(file.Outputs, cm.Generated)
+	span.end()
+
+	reason = rebuildReason(isCached, reason, job, modified)
+	if reason == "" {
+		for _, path := range modified {
+			log.file(file.Name).warnf("%s has been changed since less-tree wrote it", displayPath(path))
+		}
+		return
+	}
+
+	if len(modified) > 0 && modifiedOutputs == "keep" {
+		for _, path := range modified {
+			log.file(file.Name).warnf("not rebuilding %s, because %s has been changed since less-tree wrote it (use -modified-outputs=rebuild to overwrite it)", file.Name, displayPath(path))
+		}
 
-	for _, file := range files {
-		job := newCSSJob(file.Name, file.Dir, file.CSSDir, file.File, lesscArgs.out)
-		isCached := cm.Test(file)
-		outputFilesExist := job.OutputFilesExist()
+		// leave the entry as it was, so it's still out of date next time
+		if prev != nil {
+			cm.Files[file.Name] = prev
+		} else {
+			delete(cm.Files, file.Name)
+		}
+		return
+	}
 
-		if !isCached || !outputFilesExist || force {
-			cssQueue.Add(job)
+	if len(modified) > 0 && modifiedOutputs == "warn" {
+		for _, path := range modified {
+			log.file(file.Name).warnf("overwriting %s, which has been changed since less-tree wrote it", displayPath(path))
 		}
 	}
 
-	cm.Save()
+	log.file(file.Name).with("reason", reason).verbosef("rebuild: %s (%s)", file.Name, reason)
+	b.pending.Add(1)
+	job.finished = b.pending.Done
+	sched.add(job, file, prev)
+	b.jobs = append(b.jobs, job)
 }
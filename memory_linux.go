@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memoryStatus returns the available and total memory, in bytes, from /proc/meminfo.
+func memoryStatus() (available, total uint64, ok bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	found := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemAvailable:":
+			available = kb << 10
+			found++
+		case "MemTotal:":
+			total = kb << 10
+			found++
+		}
+	}
+
+	return available, total, found == 2
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// memoryStatus can't measure memory on this platform, so -max-jobs=auto only goes by throughput.
+func memoryStatus() (available, total uint64, ok bool) {
+	return 0, 0, false
+}
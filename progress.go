@@ -0,0 +1,388 @@
+package main
+
+import (
+	"github.com/jimmysawczuk/worker"
+
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdout and stderr are where anything printed while the build is running should go, so it doesn't get
+// mixed up with the progress view.
+var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
+
+// progressModes are the values -progress accepts.
+var progressModes = map[string]bool{
+	"auto":  true,
+	"tty":   true,
+	"plain": true,
+	"none":  true,
+}
+
+// A progressView shows how the build is going, from the css queue's events. On a terminal it keeps a few
+// lines at the bottom of the screen up to date, with the counts, an ETA, the files being built and the
+// slowest ones so far; otherwise it prints a line as each file finishes.
+type progressView struct {
+	mode  string
+	out   io.Writer
+	sched *scheduler
+
+	mu       sync.Mutex
+	total    int
+	done     int
+	failed   int
+	running  map[*worker.Package]*scheduledJob
+	slowest  []*cssJob
+	built    time.Duration
+	builtN   int
+	lines    int
+	partial  bool
+	finished bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// A progressWriter clears the progress view before writing, and draws it again after.
+type progressWriter struct {
+	p *progressView
+	w io.Writer
+}
+
+// progressSlowest is how many of the slowest files are shown, and progressRunning how many of the running
+// ones.
+const (
+	progressSlowest = 3
+	progressRunning = 5
+)
+
+// newProgressView returns a view for mode, which is one of progressModes: auto is tty on a terminal and
+// plain otherwise, unless -q or -v make it unnecessary. A tty view takes over stdout and stderr until
+// it's finished.
+func newProgressView(mode string) (*progressView, error) {
+	if !progressModes[mode] {
+		return nil, fmt.Errorf("invalid -progress %q (should be auto, tty, plain or none)", mode)
+	}
+
+	if mode == "auto" {
+		switch {
+		case !log.enabled(levelInfo):
+			mode = "none"
+		case isTerminal(os.Stderr) && !log.json:
+			mode = "tty"
+		case log.enabled(levelVerbose):
+			// each file's already logged as it's built
+			mode = "none"
+		default:
+			mode = "plain"
+		}
+	}
+
+	p := &progressView{
+		mode:    mode,
+		out:     os.Stderr,
+		running: map[*worker.Package]*scheduledJob{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if mode == "tty" {
+		stdout = progressWriter{p, os.Stdout}
+		stderr = progressWriter{p, os.Stderr}
+	}
+
+	return p, nil
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// watch follows the jobs in queue, which sched adds to. It has to be called before the queue starts.
+func (p *progressView) watch(queue *worker.Worker, sched *scheduler) {
+	p.sched = sched
+
+	if p.mode == "none" {
+		return
+	}
+
+	queue.On(worker.JobAdded, func(pk *worker.Package, args ...interface{}) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.total++
+	})
+
+	queue.On(worker.JobStarted, func(pk *worker.Package, args ...interface{}) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.running[pk] = pk.Job().(*scheduledJob)
+	})
+
+	queue.On(worker.JobFinished, func(pk *worker.Package, args ...interface{}) {
+		p.mu.Lock()
+
+		delete(p.running, pk)
+		p.done++
+		done, total := p.done, p.total
+
+		job, _ := pk.Job().(*scheduledJob).current()
+		if job == nil {
+			p.mu.Unlock()
+			return
+		}
+
+		if job.exitCode != 0 && !job.cancelled {
+			p.failed++
+		}
+
+		if job.duration > 0 {
+			p.built += job.duration
+			p.builtN++
+
+			p.slowest = append(p.slowest, job)
+			sort.SliceStable(p.slowest, func(i, j int) bool {
+				return p.slowest[i].duration > p.slowest[j].duration
+			})
+			if len(p.slowest) > progressSlowest {
+				p.slowest = p.slowest[:progressSlowest]
+			}
+		}
+
+		p.mu.Unlock()
+
+		if p.mode == "plain" {
+			log.file(job.Name).with("done", done).with("total", total).with("duration", job.duration).infof("[%d/%d] %s", done, total, jobOutcome(job))
+		}
+	})
+}
+
+// jobOutcome describes how a finished job went, like "ok: admin.less (1.2s)".
+func jobOutcome(job *cssJob) string {
+	switch {
+	case job.cancelled:
+		return "cancelled: " + job.Name
+	case job.timedOut:
+		return "timed out: " + job.Name
+	case job.exitCode != 0:
+		return "failed: " + job.Name
+	case job.restored:
+		return "restored: " + job.Name
+	}
+
+	return fmt.Sprintf("ok: %s (%s)", job.Name, roundDuration(job.duration))
+}
+
+// start starts redrawing a tty view.
+func (p *progressView) start() {
+	if p.mode != "tty" {
+		close(p.stopped)
+		return
+	}
+
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				p.clear()
+				p.draw()
+				p.mu.Unlock()
+
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish stops and clears the view, and returns a line listing the slowest files, if any were built.
+func (p *progressView) finish() string {
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	p.finished = true
+
+	if len(p.slowest) == 0 {
+		return ""
+	}
+
+	return "Slowest: " + p.slowestList()
+}
+
+func (p *progressView) slowestList() string {
+	slowest := []string{}
+	for _, job := range p.slowest {
+		slowest = append(slowest, fmt.Sprintf("%s (%s)", job.Name, roundDuration(job.duration)))
+	}
+
+	return strings.Join(slowest, ", ")
+}
+
+// clear erases the view, leaving the cursor where it started.
+func (p *progressView) clear() {
+	if p.lines == 0 {
+		return
+	}
+
+	fmt.Fprint(p.out, "\r")
+	if p.lines > 1 {
+		fmt.Fprintf(p.out, "\x1b[%dA", p.lines-1)
+	}
+	fmt.Fprint(p.out, "\x1b[J")
+	p.lines = 0
+}
+
+// draw draws the view below whatever's been written so far. The cursor is left at the end of its last
+// line, so clear can find its way back.
+func (p *progressView) draw() {
+	if p.finished || p.total == 0 {
+		return
+	}
+
+	if p.partial {
+		fmt.Fprintln(p.out)
+		p.partial = false
+	}
+
+	now := time.Now()
+
+	type runningJob struct {
+		name    string
+		elapsed time.Duration
+	}
+	running := []runningJob{}
+	for _, sj := range p.running {
+		if job, started := sj.current(); job != nil {
+			running = append(running, runningJob{job.Name, now.Sub(started)})
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].elapsed > running[j].elapsed
+	})
+
+	status := fmt.Sprintf("[%d/%d] %d running", p.done, p.total, len(running))
+	if p.failed > 0 {
+		status += fmt.Sprintf(", %d failed", p.failed)
+	}
+	if eta, ok := p.eta(now); ok {
+		status += fmt.Sprintf(", about %s left", roundDuration(eta))
+	}
+
+	lines := []string{status}
+	for i, r := range running {
+		if i == progressRunning {
+			lines = append(lines, fmt.Sprintf("  and %d more", len(running)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %s (%s)", r.name, roundDuration(r.elapsed)))
+	}
+	if len(p.slowest) > 0 {
+		lines = append(lines, "  slowest: "+p.slowestList())
+	}
+
+	width := terminalWidth()
+	for i, line := range lines {
+		if len(line) > width-1 {
+			lines[i] = line[:width-1]
+		}
+	}
+
+	fmt.Fprint(p.out, strings.Join(lines, "\n"))
+	p.lines = len(lines)
+}
+
+// eta estimates how long is left from how long each file took last time. Files that haven't been built
+// before are expected to take as long as the average.
+func (p *progressView) eta(now time.Time) (time.Duration, bool) {
+	known, knownN, unknownN := p.sched.pending()
+
+	total, n := p.built+known, p.builtN+knownN
+	for _, sj := range p.running {
+		if job, _ := sj.current(); job != nil && job.expected > 0 {
+			total += job.expected
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	average := total / time.Duration(n)
+
+	remaining := known + time.Duration(unknownN)*average
+	parallel := 0
+	for _, sj := range p.running {
+		job, started := sj.current()
+		if job == nil {
+			continue
+		}
+		parallel++
+
+		expected := job.expected
+		if expected == 0 {
+			expected = average
+		}
+		if left := expected - now.Sub(started); left > 0 {
+			remaining += left
+		}
+	}
+
+	if parallel == 0 {
+		parallel = 1
+	}
+
+	return remaining / time.Duration(parallel), true
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+
+	w.p.clear()
+	n, err := w.w.Write(b)
+	if len(b) > 0 {
+		w.p.partial = b[len(b)-1] != '\n'
+	}
+	w.p.draw()
+
+	return n, err
+}
+
+// terminalWidth guesses the terminal's width from $COLUMNS, falling back to 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// roundDuration rounds d for display: to the millisecond under a second, to a tenth of a second under a
+// minute, and to the second after that.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
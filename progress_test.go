@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/jimmysawczuk/worker"
+
+	"testing"
+	"time"
+)
+
+func TestProgressETA(t *testing.T) {
+	s, err := newScheduler(worker.NewWorker(), "fifo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newProgressView("none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.watch(nil, s)
+
+	if _, ok := p.eta(time.Now()); ok {
+		t.Fatal("expected no ETA without any durations to go on")
+	}
+
+	s.add(&cssJob{Name: "a.less"}, &lessFile{}, &lessFile{Duration: 4 * time.Second})
+	s.add(&cssJob{Name: "b.less"}, &lessFile{}, &lessFile{Duration: 2 * time.Second})
+	s.add(&cssJob{Name: "new.less"}, &lessFile{}, nil)
+
+	// a.less has been running for a second, on its own
+	now := time.Now()
+	running := &scheduledJob{s: s, job: s.next(), started: now.Add(-time.Second)}
+	p.running[&worker.Package{}] = running
+
+	// 3s left of a.less, 2s of b.less and the average of 3s for new.less
+	if eta, ok := p.eta(now); !ok || eta != 8*time.Second {
+		t.Errorf("expected 8s, got %s", eta)
+	}
+}
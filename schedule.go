@@ -0,0 +1,182 @@
+package main
+
+import (
+	"github.com/jimmysawczuk/worker"
+
+	"container/heap"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// A scheduler decides the order entry points are compiled in. The worker's queue only runs jobs in the
+// order they were added, so for each job the scheduler adds a placeholder to it instead, and when the
+// placeholder is started it runs whichever job has the highest priority at that point.
+type scheduler struct {
+	queue  *worker.Worker
+	policy string
+	limit  jobLimiter
+
+	mu   sync.Mutex
+	jobs jobHeap
+	seq  int
+}
+
+// A scheduledJob is the placeholder added to the worker's queue for each job. job is set once it's
+// started, so anything that might look at it before the placeholder finishes should use current.
+type scheduledJob struct {
+	s   *scheduler
+	job *cssJob
+
+	mu      sync.Mutex
+	started time.Time
+}
+
+type scheduledItem struct {
+	job      *cssJob
+	priority int64
+	seq      int
+}
+
+// A jobHeap is a container/heap of jobs, highest priority first, then first come first served.
+type jobHeap []scheduledItem
+
+// schedulePolicies are the orders -schedule accepts.
+var schedulePolicies = map[string]bool{
+	"duration": true,
+	"mtime":    true,
+	"fifo":     true,
+}
+
+// newScheduler returns a scheduler that adds jobs to queue in the order given by policy. If limit isn't
+// nil, each job waits for it before starting.
+func newScheduler(queue *worker.Worker, policy string, limit jobLimiter) (*scheduler, error) {
+	if !schedulePolicies[policy] {
+		return nil, fmt.Errorf("invalid schedule %q (should be duration, mtime or fifo)", policy)
+	}
+
+	return &scheduler{
+		queue:  queue,
+		policy: policy,
+		limit:  limit,
+	}, nil
+}
+
+// add schedules job, which builds file. prev is file's entry from the cache, if it had one.
+func (s *scheduler) add(job *cssJob, file, prev *lessFile) {
+	if prev != nil {
+		job.expected = prev.Duration
+	}
+
+	s.mu.Lock()
+	heap.Push(&s.jobs, scheduledItem{job: job, priority: s.priority(file, prev), seq: s.seq})
+	s.seq++
+	s.mu.Unlock()
+
+	s.queue.Add(&scheduledJob{s: s})
+}
+
+// priority ranks file according to the policy. Files that have never been built before go first when
+// scheduling by duration, since there's no telling how long they'll take.
+func (s *scheduler) priority(file, prev *lessFile) int64 {
+	switch s.policy {
+	case "duration":
+		if prev == nil || prev.Duration <= 0 {
+			return math.MaxInt64
+		}
+		return int64(prev.Duration)
+
+	case "mtime":
+		return newestModTime(file).UnixNano()
+	}
+
+	return 0
+}
+
+func (s *scheduler) next() *cssJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs.Len() == 0 {
+		return nil
+	}
+
+	return heap.Pop(&s.jobs).(scheduledItem).job
+}
+
+// pending sums up the jobs that haven't started yet: the total time they took last time for the ones
+// that have been built before, how many of those there are, and how many haven't been.
+func (s *scheduler) pending() (known time.Duration, knownN, unknownN int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range s.jobs {
+		if item.job.expected > 0 {
+			known += item.job.expected
+			knownN++
+		} else {
+			unknownN++
+		}
+	}
+
+	return known, knownN, unknownN
+}
+
+func (j *scheduledJob) Run() {
+	if j.s.limit != nil {
+		j.s.limit.acquire()
+		defer j.s.limit.release()
+	}
+
+	job := j.s.next()
+
+	j.mu.Lock()
+	j.job, j.started = job, time.Now()
+	j.mu.Unlock()
+
+	if job != nil {
+		job.Run()
+	}
+}
+
+// current returns the job the placeholder is running, and when it started, or nil if it hasn't started
+// one yet.
+func (j *scheduledJob) current() (*cssJob, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.job, j.started
+}
+
+// newestModTime returns the latest modification time of l and everything it imports.
+func newestModTime(l *lessFile) time.Time {
+	newest := l.ModTime
+	for _, imp := range l.Imports {
+		if t := newestModTime(imp.File); t.After(newest) {
+			newest = t
+		}
+	}
+
+	return newest
+}
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(scheduledItem)) }
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/jimmysawczuk/worker"
+
+	"testing"
+	"time"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	now := time.Now()
+	files := []struct {
+		name     string
+		duration time.Duration
+		modTime  time.Time
+	}{
+		{"fast.less", time.Second, now.Add(-time.Hour)},
+		{"new.less", 0, now.Add(-2 * time.Hour)},
+		{"slow.less", time.Minute, now.Add(-3 * time.Hour)},
+		{"edited.less", 2 * time.Second, now},
+	}
+
+	tests := map[string][]string{
+		"duration": {"new.less", "slow.less", "edited.less", "fast.less"},
+		"mtime":    {"edited.less", "fast.less", "new.less", "slow.less"},
+		"fifo":     {"fast.less", "new.less", "slow.less", "edited.less"},
+	}
+
+	for policy, expected := range tests {
+		s, err := newScheduler(worker.NewWorker(), policy, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range files {
+			var prev *lessFile
+			if f.duration > 0 {
+				prev = &lessFile{Name: f.name, Duration: f.duration}
+			}
+			s.add(&cssJob{Name: f.name}, &lessFile{Name: f.name, ModTime: f.modTime}, prev)
+		}
+
+		for i, name := range expected {
+			if job := s.next(); job == nil || job.Name != name {
+				t.Errorf("%s: expected job %d to be %s, got %v", policy, i, name, job)
+			}
+		}
+	}
+
+	if _, err := newScheduler(worker.NewWorker(), "random", nil); err == nil {
+		t.Error("expected an invalid policy to be rejected")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A rootState says where the cache file and lock for a root live.
+type rootState struct {
+	cache string
+	lock  string
+}
+
+func init() {
+	commands["migrate-cache"] = &command{
+		args:        "<dir> <another-dir>...",
+		description: "Move the cache file for each root out of its css directory and into the state directory",
+		run:         migrateCacheCommand,
+	}
+}
+
+// stateFor returns where the state for root, whose css directory is cssDir, lives. By default that's in
+// cssDir itself; with a state directory, it's in a subdirectory named after the root and a hash of its
+// absolute path, so roots with the same name don't collide.
+func stateFor(root, cssDir string) rootState {
+	if stateDir == "" {
+		return rootState{
+			cache: filepath.Join(cssDir, ".less-tree-cache"),
+			lock:  filepath.Join(cssDir, ".less-tree-lock"),
+		}
+	}
+
+	abs, _ := filepath.Abs(root)
+	sum := sha1.Sum([]byte(abs))
+	dir := filepath.Join(stateDir, filepath.Base(abs)+"-"+hex.EncodeToString(sum[:])[:12])
+
+	return rootState{
+		cache: filepath.Join(dir, "cache.json"),
+		lock:  filepath.Join(dir, "lock"),
+	}
+}
+
+// rootDirs returns the absolute paths of the root dir given on the command line and its css and less
+// directories.
+func rootDirs(dir string) (root, cssDir, lessDir string) {
+	root = dir
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(workingDirectory, root)
+	}
+	root = filepath.Clean(root)
+
+	return root, filepath.Join(root, "css"), filepath.Join(root, "less")
+}
+
+// loadState finds the working directory and reads the config file, for subcommands that need to find
+// each root's state.
+func loadState() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("can't find the working directory")
+	}
+	workingDirectory = wd
+
+	cfg, err = loadGlobalConfig()
+	if err != nil {
+		return err
+	}
+
+	if stateDir == "" {
+		stateDir = cfg.StateDir
+	}
+
+	return nil
+}
+
+func migrateCacheCommand(args []string) int {
+	if err := loadState(); err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	fs := flag.NewFlagSet("migrate-cache", flag.ExitOnError)
+	fs.StringVar(&stateDir, "state-dir", stateDir, "Directory to move the cache files to (defaults to -state-dir or the stateDir setting)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: less-tree migrate-cache [options] <dir> <another-dir>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitEnvironment
+	}
+
+	if stateDir == "" {
+		fmt.Fprintln(os.Stderr, "less-tree: there's nowhere to migrate to; pass -state-dir or set stateDir in "+defaultConfigPath)
+		return exitEnvironment
+	}
+
+	status := exitOK
+	for _, dir := range fs.Args() {
+		if err := migrateCache(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "less-tree: can't migrate %s: %s\n", dir, err)
+			status = exitEnvironment
+		}
+	}
+
+	return status
+}
+
+// migrateCache moves the cache file for the root dir from its css directory to the state directory.
+func migrateCache(dir string) error {
+	root, cssDir, _ := rootDirs(dir)
+
+	from := filepath.Join(cssDir, ".less-tree-cache")
+	to := stateFor(root, cssDir)
+
+	// Lock both the old and the new location, so no run using either is in the middle of a build. The old
+	// lock file is left behind afterwards, like any other (see unlock).
+	oldLock, err := acquireLock(context.Background(), filepath.Join(cssDir, ".less-tree-lock"), cssDir, lockTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer oldLock.Release()
+
+	newLock, err := acquireLock(context.Background(), to.lock, cssDir, lockTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer newLock.Release()
+
+	contents, err := ioutil.ReadFile(from)
+	if os.IsNotExist(err) {
+		fmt.Printf("nothing to migrate for %s\n", dir)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(to.cache); err == nil {
+		return fmt.Errorf("there's already a cache file at %s; delete one of them and try again", displayPath(to.cache))
+	}
+
+	// Only move caches that can be read, and copy them byte for byte so nothing (like the time they were
+	// written) changes along the way. One that can't be read is left where it is, rather than throwing
+	// away something that might be worth a look.
+	if err := newLessTreeCache(from).Load(); err != nil {
+		if loadErr, ok := err.(cacheLoadError); ok {
+			return fmt.Errorf("%s can't be used, because %s; delete it to rebuild everything instead", displayPath(from), loadErr.reason)
+		}
+		return err
+	}
+
+	if err := writeFileAtomic(to.cache, contents, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Remove(from); err != nil {
+		return err
+	}
+
+	fmt.Printf("migrated %s to %s\n", displayPath(from), displayPath(to.cache))
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateFor(t *testing.T) {
+	defer func(dir string) { stateDir = dir }(stateDir)
+
+	stateDir = ""
+	if s := stateFor("/srv/public", "/srv/public/css"); s.cache != filepath.Join("/srv/public/css", ".less-tree-cache") || s.lock != filepath.Join("/srv/public/css", ".less-tree-lock") {
+		t.Errorf("expected the state to be in the css directory without a state directory, got %+v", s)
+	}
+
+	stateDir = filepath.Join(t.TempDir(), "state")
+	a, _ := filepath.Abs(filepath.Join("site-a", "public"))
+	b, _ := filepath.Abs(filepath.Join("site-b", "public"))
+
+	sum := sha1.Sum([]byte(a))
+	dir := filepath.Join(stateDir, "public-"+hex.EncodeToString(sum[:])[:12])
+
+	s := stateFor(a, filepath.Join(a, "css"))
+	if s.cache != filepath.Join(dir, "cache.json") || s.lock != filepath.Join(dir, "lock") {
+		t.Errorf("expected the state to be in %s, got %+v", dir, s)
+	}
+
+	if other := stateFor(b, filepath.Join(b, "css")); filepath.Dir(other.cache) == filepath.Dir(s.cache) {
+		t.Errorf("expected roots with the same name to get different directories, got %s for both", filepath.Dir(s.cache))
+	}
+}
+
+func TestMigrateCache(t *testing.T) {
+	defer func(dir, wd string, timeout time.Duration) {
+		stateDir, workingDirectory, lockTimeout = dir, wd, timeout
+	}(stateDir, workingDirectory, lockTimeout)
+
+	lockTimeout = 200 * time.Millisecond
+	valid := []byte(`{"schema": 2, "version": "1.6.0", "files": {"a.less": {"name": "a.less", "hash": "x"}}}`)
+
+	// setup makes a root called public with contents as its old cache (unless it's nil), and returns the
+	// old cache's path and where it should be migrated to.
+	setup := func(t *testing.T, contents []byte) (string, rootState) {
+		t.Helper()
+
+		workingDirectory = t.TempDir()
+		stateDir = filepath.Join(workingDirectory, "state")
+
+		cssDir := filepath.Join(workingDirectory, "public", "css")
+		if err := os.MkdirAll(cssDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		from := filepath.Join(cssDir, ".less-tree-cache")
+		if contents != nil {
+			if err := ioutil.WriteFile(from, contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return from, stateFor(filepath.Join(workingDirectory, "public"), cssDir)
+	}
+
+	// unmoved checks the old cache is still there as it was.
+	unmoved := func(t *testing.T, from string, contents []byte) {
+		t.Helper()
+
+		if actual, err := ioutil.ReadFile(from); err != nil || string(actual) != string(contents) {
+			t.Errorf("expected the old cache to be left alone, got %q, %v", actual, err)
+		}
+	}
+
+	t.Run("moved", func(t *testing.T) {
+		from, to := setup(t, valid)
+
+		if err := migrateCache("public"); err != nil {
+			t.Fatal(err)
+		}
+
+		if contents, err := ioutil.ReadFile(to.cache); err != nil || string(contents) != string(valid) {
+			t.Errorf("expected the cache to be copied byte for byte, got %q, %v", contents, err)
+		}
+		if _, err := os.Stat(from); !os.IsNotExist(err) {
+			t.Errorf("expected the old cache to be removed, got %v", err)
+		}
+	})
+
+	t.Run("nothing to migrate", func(t *testing.T) {
+		_, to := setup(t, nil)
+
+		if err := migrateCache("public"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(to.cache); !os.IsNotExist(err) {
+			t.Errorf("expected no cache to be written, got %v", err)
+		}
+	})
+
+	t.Run("target exists", func(t *testing.T) {
+		from, to := setup(t, valid)
+		writeFileAtomic(to.cache, []byte(`{}`), 0644)
+
+		if err := migrateCache("public"); err == nil || !strings.Contains(err.Error(), "already a cache file") {
+			t.Fatalf("expected migrating over an existing cache to fail, got %v", err)
+		}
+		unmoved(t, from, valid)
+		if contents, _ := ioutil.ReadFile(to.cache); string(contents) != `{}` {
+			t.Errorf("expected the existing cache to be left alone, got %q", contents)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		corrupt := []byte(`{"schema": 2, "files": `)
+		from, to := setup(t, corrupt)
+
+		if err := migrateCache("public"); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Fatalf("expected a corrupt cache not to be migrated, got %v", err)
+		}
+		unmoved(t, from, corrupt)
+		if _, err := os.Stat(to.cache); !os.IsNotExist(err) {
+			t.Errorf("expected no cache to be written, got %v", err)
+		}
+	})
+
+	t.Run("unreadable", func(t *testing.T) {
+		from, to := setup(t, nil)
+		if err := os.Mkdir(from, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := migrateCache("public"); err == nil {
+			t.Fatal("expected a cache that can't be read not to be migrated")
+		}
+		if fi, err := os.Stat(from); err != nil || !fi.IsDir() {
+			t.Errorf("expected the old cache to be left alone, got %v", err)
+		}
+		if _, err := os.Stat(to.cache); !os.IsNotExist(err) {
+			t.Errorf("expected no cache to be written, got %v", err)
+		}
+	})
+
+	// Each of the locks has to be free, or the cache isn't touched.
+	for _, which := range []string{"old", "new"} {
+		t.Run(which+" lock held", func(t *testing.T) {
+			from, to := setup(t, valid)
+
+			path := to.lock
+			if which == "old" {
+				path = filepath.Join(filepath.Dir(from), ".less-tree-lock")
+			}
+			lock, err := acquireLock(context.Background(), path, filepath.Dir(from), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lock.Release()
+
+			if err := migrateCache("public"); err == nil || !strings.Contains(err.Error(), "timed out") {
+				t.Fatalf("expected to wait for the %s lock and time out, got %v", which, err)
+			}
+			unmoved(t, from, valid)
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// atRules are the at-keywords that start a CSS or LESS rule rather than naming a variable.
+var atRules = map[string]bool{
+	"@charset":             true,
+	"@container":           true,
+	"@counter-style":       true,
+	"@document":            true,
+	"@font-face":           true,
+	"@font-feature-values": true,
+	"@import":              true,
+	"@keyframes":           true,
+	"@layer":               true,
+	"@media":               true,
+	"@namespace":           true,
+	"@page":                true,
+	"@plugin":              true,
+	"@property":            true,
+	"@supports":            true,
+	"@viewport":            true,
+}
+
+// builtinVariables are always defined, either inside any mixin or inside each().
+var builtinVariables = map[string]bool{
+	"@arguments": true,
+	"@index":     true,
+	"@key":       true,
+	"@value":     true,
+}
+
+var interpolation = regexp.MustCompile(`@\{([A-Za-z0-9_\-]+)\}`)
+
+type symbolKind int
+
+const (
+	symbolVariable symbolKind = iota
+	symbolMixin
+)
+
+func (k symbolKind) String() string {
+	if k == symbolMixin {
+		return "mixin"
+	}
+	return "variable"
+}
+
+// A symbolRef is a single place where a variable or mixin is defined or used.
+type symbolRef struct {
+	Kind symbolKind
+	Name string
+	Pos  position
+	Def  bool
+
+	// In is the variable being declared, if this is a use inside a variable declaration.
+	In string
+
+	file *graphFile
+}
+
+// A symbolIndex records where every variable and mixin in an import graph is defined and used.
+type symbolIndex struct {
+	graph *importGraph
+	refs  map[string][]symbolRef
+}
+
+type symbolReport struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Definitions []string `json:"definitions"`
+	Uses        []string `json:"uses"`
+	Dependents  []string `json:"dependents"`
+	EntryPoints []string `json:"entryPoints"`
+}
+
+func init() {
+	commands["symbols"] = &command{
+		args:        "<dir> [@variable|.mixin]...",
+		description: "Show where variables and mixins are defined and used, and which entry points use them",
+		run:         symbolsCommand,
+	}
+}
+
+func symbolsCommand(args []string) int {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("symbols", flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", false, "Print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: less-tree symbols [options] <dir> [@variable|.mixin]...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitEnvironment
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "less-tree: can't find the working directory")
+		return exitEnvironment
+	}
+	workingDirectory = wd
+
+	root, _ := filepath.Abs(fs.Arg(0))
+	g, err := newImportGraph(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "less-tree: %s\n", err)
+		return exitEnvironment
+	}
+
+	idx := newSymbolIndex(g)
+
+	names := fs.Args()[1:]
+	if len(names) == 0 {
+		names = idx.names()
+	}
+
+	reports := []symbolReport{}
+	missing := false
+	for _, name := range names {
+		if _, exists := idx.refs[name]; !exists {
+			fmt.Fprintf(os.Stderr, "less-tree: %s isn't defined or used anywhere in %s\n", name, displayPath(g.root))
+			missing = true
+			continue
+		}
+
+		reports = append(reports, idx.report(name))
+	}
+
+	if asJSON {
+		contents, _ := json.MarshalIndent(reports, "", "\t")
+		fmt.Println(string(contents))
+	} else if len(fs.Args()) == 1 {
+		for _, r := range reports {
+			fmt.Printf("%s (%s): %d definitions, %d uses, %d entry points\n", r.Name, r.Kind, len(r.Definitions), len(r.Uses), len(r.EntryPoints))
+		}
+	} else {
+		for _, r := range reports {
+			fmt.Printf("%s (%s)\n", r.Name, r.Kind)
+			printSymbolList("defined", r.Definitions)
+			printSymbolList("used", r.Uses)
+			printSymbolList("variables defined using it", r.Dependents)
+			printSymbolList("entry points", r.EntryPoints)
+		}
+	}
+
+	if missing {
+		return exitEnvironment
+	}
+
+	return exitOK
+}
+
+func printSymbolList(title string, list []string) {
+	fmt.Printf("  %s (%d):\n", title, len(list))
+	for _, v := range list {
+		fmt.Printf("   - %s\n", v)
+	}
+}
+
+func newSymbolIndex(g *importGraph) *symbolIndex {
+	idx := &symbolIndex{
+		graph: g,
+		refs:  map[string][]symbolRef{},
+	}
+
+	for _, f := range g.sorted() {
+		for _, ref := range findSymbols(f.tokens) {
+			ref.file = f
+			idx.refs[ref.Name] = append(idx.refs[ref.Name], ref)
+		}
+	}
+
+	return idx
+}
+
+func (idx *symbolIndex) names() []string {
+	names := make([]string, 0, len(idx.refs))
+	for name := range idx.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// dependents returns the variables whose values are built from name, directly or indirectly, sorted by
+// name.
+func (idx *symbolIndex) dependents(name string) []string {
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		for _, ref := range idx.refs[queue[0]] {
+			if ref.In != "" && !seen[ref.In] {
+				seen[ref.In] = true
+				queue = append(queue, ref.In)
+			}
+		}
+		queue = queue[1:]
+	}
+
+	delete(seen, name)
+
+	names := []string{}
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// entryPoints returns the entry points that use name somewhere in their imports, either directly or
+// through a variable that depends on it.
+func (idx *symbolIndex) entryPoints(name string) []*graphFile {
+	using := map[*graphFile]bool{}
+	for _, n := range append(idx.dependents(name), name) {
+		for _, ref := range idx.refs[n] {
+			if !ref.Def {
+				using[ref.file] = true
+			}
+		}
+	}
+
+	entries := []*graphFile{}
+	for _, entry := range idx.graph.entries() {
+		for _, f := range idx.graph.closure(entry) {
+			if using[f] {
+				entries = append(entries, entry)
+				break
+			}
+		}
+	}
+
+	return entries
+}
+
+func (idx *symbolIndex) report(name string) symbolReport {
+	refs := idx.refs[name]
+
+	r := symbolReport{
+		Name:        name,
+		Kind:        refs[0].Kind.String(),
+		Definitions: []string{},
+		Uses:        []string{},
+		Dependents:  idx.dependents(name),
+		EntryPoints: []string{},
+	}
+
+	for _, ref := range refs {
+		loc := fmt.Sprintf("%s:%s", displayPath(ref.file.Path), ref.Pos)
+		if ref.Def {
+			r.Definitions = append(r.Definitions, loc)
+		} else {
+			r.Uses = append(r.Uses, loc)
+		}
+	}
+
+	for _, entry := range idx.entryPoints(name) {
+		r.EntryPoints = append(r.EntryPoints, entry.Name)
+	}
+
+	return r
+}
+
+// findSymbols returns every variable and mixin defined or used in tokens. Variable declarations, mixin
+// parameters and rulesets that can be called as mixins count as definitions; everything else, including
+// interpolations, counts as a use.
+func findSymbols(tokens []token) []symbolRef {
+	refs := []symbolRef{}
+
+	start, depth := 0, 0
+	for i, tok := range tokens {
+		switch tok.kind {
+		case tokenLParen:
+			depth++
+			continue
+
+		case tokenRParen:
+			if depth > 0 {
+				depth--
+			}
+			continue
+
+		case tokenSemicolon:
+			if depth > 0 {
+				continue
+			}
+
+		case tokenLCurly, tokenRCurly:
+			depth = 0
+
+		default:
+			continue
+		}
+
+		refs = append(refs, statementSymbols(tokens[start:i], tok.kind)...)
+		start = i + 1
+	}
+	refs = append(refs, statementSymbols(tokens[start:], tokenSemicolon)...)
+
+	return refs
+}
+
+// statementSymbols finds the symbols in a single statement, given the token that ends it.
+func statementSymbols(stmt []token, end tokenKind) []symbolRef {
+	refs := []symbolRef{}
+	if len(stmt) == 0 {
+		return refs
+	}
+
+	first := stmt[0]
+	startsWithMixin := first.kind == tokenWord && isMixinName(first.value)
+	mixinDefinition := end == tokenLCurly && startsWithMixin
+
+	if mixinDefinition && (len(stmt) == 1 || stmt[1].kind == tokenLParen || stmt[1].value == "when") {
+		refs = append(refs, symbolRef{Kind: symbolMixin, Name: first.value, Pos: first.pos, Def: true})
+	} else if end != tokenLCurly && startsWithMixin {
+		// a mixin call, possibly namespaced like #gradient > .vertical(...)
+		call := first
+		for _, tok := range stmt {
+			if tok.kind == tokenLParen {
+				break
+			}
+			if tok.kind == tokenWord && isMixinName(tok.value) {
+				call = tok
+			}
+		}
+		refs = append(refs, symbolRef{Kind: symbolMixin, Name: call.value, Pos: call.pos})
+	}
+
+	declaring := ""
+	if first.kind == tokenAtKeyword && len(stmt) > 1 && stmt[1].kind == tokenColon {
+		declaring = first.value
+	}
+
+	depth := 0
+	for i, tok := range stmt {
+		switch tok.kind {
+		case tokenLParen:
+			depth++
+
+		case tokenRParen:
+			depth--
+
+		case tokenAtKeyword:
+			if isAtRule(tok.value) {
+				continue
+			}
+
+			name := tok.value
+			if strings.HasPrefix(name, "@@") {
+				name = name[1:]
+			}
+
+			isParam := mixinDefinition && depth > 0
+			if i+1 < len(stmt) && stmt[i+1].kind == tokenColon {
+				if i == 0 || isParam {
+					refs = append(refs, symbolRef{Kind: symbolVariable, Name: name, Pos: tok.pos, Def: true})
+				}
+
+				// otherwise, it's the name of a named argument in a mixin call
+				continue
+			}
+
+			refs = append(refs, symbolRef{Kind: symbolVariable, Name: name, Pos: tok.pos, Def: isParam, In: declaring})
+
+		case tokenInterpolation, tokenString, tokenEscape, tokenURL:
+			for _, match := range interpolation.FindAllStringSubmatch(tok.value, -1) {
+				refs = append(refs, symbolRef{Kind: symbolVariable, Name: "@" + match[1], Pos: tok.pos, In: declaring})
+			}
+		}
+	}
+
+	return refs
+}
+
+func isAtRule(keyword string) bool {
+	return atRules[keyword] || strings.HasPrefix(keyword, "@-") || strings.HasSuffix(keyword, "keyframes")
+}
+
+func isMixinName(word string) bool {
+	return len(word) > 1 && (word[0] == '.' || word[0] == '#')
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFindSymbols(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{`@a: 1px;`, []string{"def @a"}},
+		{`.b { width: @a; }`, []string{"def .b", "use @a"}},
+		{`.m(@a; @b: 2px; @rest...) when (@a > 0) { width: @b; }`, []string{"def .m", "def @a", "def @b", "def @rest", "def @a", "use @b"}},
+		{`.x { .m(@a: 1; @b: @c); }`, []string{"def .x", "use .m", "use @c"}},
+		{`.y { #gradient > .vertical(red; blue); .clearfix; }`, []string{"def .y", "use .vertical", "use .clearfix"}},
+		{`.a .b { } .c:hover { }`, nil},
+		{`@media (max-width: @screen) { }`, []string{"use @screen"}},
+		{`.col-@{name} { content: "@{text}"; a: @@var; }`, []string{"use @name", "use @text", "use @var"}},
+		{`@font-face { font-family: x; } @-webkit-keyframes y { }`, nil},
+		{`@detached: { color: red; }; .a { @detached(); }`, []string{"def @detached", "def .a", "use @detached"}},
+	}
+
+	for _, test := range tests {
+		actual := []string{}
+		for _, ref := range findSymbols(tokenize([]byte(test.in))) {
+			kind := "use"
+			if ref.Def {
+				kind = "def"
+			}
+			actual = append(actual, fmt.Sprintf("%s %s", kind, ref.Name))
+		}
+
+		if strings.Join(actual, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("findSymbols(%q):\nexpected: %q\nactual:   %q", test.in, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// A tracer records spans of time spent on each part of the build, for -profile, and writes them in
+// Chrome's trace event format. A nil tracer records nothing, so the spans can be left in place when
+// profiling's off.
+type tracer struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []traceEvent
+	lanes  []bool
+}
+
+// A traceSpan is a span that's been started and not yet ended.
+type traceSpan struct {
+	t     *tracer
+	event traceEvent
+	start time.Time
+
+	// nested is set for a child span, which borrows its parent's lane rather than having one of its own
+	nested bool
+}
+
+// A traceEvent is an event in the trace event format. Only complete events ("X"), which have a start
+// and a duration, and metadata ("M") are used. Times are in microseconds.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// trace is the tracer for -profile, or nil.
+var trace *tracer
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// begin starts a span called name in the category cat, about file if it isn't "". Every span that's
+// running at the same time gets a lane (a thread, as far as the trace viewer's concerned) of its own.
+func (t *tracer) begin(cat, name, file string) *traceSpan {
+	if t == nil {
+		return nil
+	}
+
+	s := &traceSpan{
+		t:     t,
+		start: time.Now(),
+		event: traceEvent{Name: name, Cat: cat, Ph: "X", Pid: 1},
+	}
+	if file != "" {
+		s.event.Args = map[string]interface{}{"file": file}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.event.Tid = len(t.lanes)
+	for i, busy := range t.lanes {
+		if !busy {
+			s.event.Tid = i
+			break
+		}
+	}
+	if s.event.Tid == len(t.lanes) {
+		t.lanes = append(t.lanes, true)
+	}
+	t.lanes[s.event.Tid] = true
+
+	return s
+}
+
+// child starts a span called name within s, about file if it isn't "", for a step of the same work. It's
+// put in s's lane, so the trace viewer shows it nested under s.
+func (s *traceSpan) child(name, file string) *traceSpan {
+	if s == nil {
+		return nil
+	}
+
+	c := &traceSpan{
+		t:      s.t,
+		start:  time.Now(),
+		event:  traceEvent{Name: name, Cat: s.event.Cat, Ph: "X", Pid: 1, Tid: s.event.Tid},
+		nested: true,
+	}
+	if file != "" {
+		c.event.Args = map[string]interface{}{"file": file}
+	}
+
+	return c
+}
+
+// end ends the span and records it.
+func (s *traceSpan) end() {
+	if s == nil {
+		return
+	}
+
+	end := time.Now()
+	s.event.Ts = float64(s.start.Sub(s.t.start).Nanoseconds()) / 1e3
+	s.event.Dur = float64(end.Sub(s.start).Nanoseconds()) / 1e3
+
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+
+	s.t.events = append(s.t.events, s.event)
+	if !s.nested {
+		s.t.lanes[s.event.Tid] = false
+	}
+}
+
+// Save writes the trace to path, as JSON that can be loaded into chrome://tracing or ui.perfetto.dev.
+func (t *tracer) Save(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := []traceEvent{{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]interface{}{"name": "less-tree"},
+	}}
+	events = append(events, t.events...)
+
+	out, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, out, 0644)
+}
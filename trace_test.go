@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	// a nil tracer records nothing, without complaining
+	var off *tracer
+	off.begin("analyze", "hash", "a.less").end()
+
+	tr := newTracer()
+	outer := tr.begin("compile", "lessc", "a.less")
+	inner := tr.begin("compile", "lessc", "b.less")
+	inner.end()
+	again := tr.begin("compile", "cssmin", "b.less")
+	again.end()
+	outer.end()
+
+	if outer.event.Tid == inner.event.Tid {
+		t.Error("expected spans running at the same time to get lanes of their own")
+	}
+	if again.event.Tid != inner.event.Tid {
+		t.Error("expected a finished span's lane to be reused")
+	}
+
+	// a job's steps stay in its lane, even when another span is started between them
+	var offStep *traceSpan
+	offStep.child("hash", "a.less").end()
+
+	job := tr.begin("compile", "compile", "c.less")
+	lessc := job.child("lessc", "c.less")
+	lessc.end()
+	other := tr.begin("analyze", "analyze", "d.less")
+	cssmin := job.child("cssmin", "c.less")
+	cssmin.end()
+	other.end()
+	job.end()
+
+	if lessc.event.Tid != job.event.Tid || cssmin.event.Tid != job.event.Tid || cssmin.event.Cat != "compile" {
+		t.Errorf("expected a job's steps to be in its lane %d, got %d and %d", job.event.Tid, lessc.event.Tid, cssmin.event.Tid)
+	}
+	if other.event.Tid == job.event.Tid {
+		t.Error("expected a span started during a job's step to get a lane of its own")
+	}
+
+	path := filepath.Join(t.TempDir(), "out.json")
+	if err := tr.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(contents, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// the process name, then the spans in the order they ended
+	names := []string{}
+	for _, e := range saved.TraceEvents {
+		names = append(names, e.Name)
+	}
+	if len(names) != 8 || names[0] != "process_name" || names[3] != "lessc" || saved.TraceEvents[3].Args["file"] != "a.less" || names[7] != "compile" {
+		t.Errorf("unexpected events %v", saved.TraceEvents)
+	}
+}